@@ -1,32 +1,61 @@
-package main
+package flake
 
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-func applyToFlake(currentPath string) error {
+// .flk files, relative to flake.nix
+const (
+	shellHookPath     = ".flk/devenv/shellhook.sh"
+	packageYAMLPath   = ".flk/derivation/package.yml"
+	buildScriptPath   = ".flk/derivation/build.sh"
+	installScriptPath = ".flk/derivation/install.sh"
+)
+
+// Apply writes the .flk folder next to flake.nix into the flake, and those of the workspace members into their flk.nix
+func (f *Flake) Apply() error {
+	if err := f.ensureShellHookBlock(); err != nil {
+		return err
+	}
 	// apply flk changes
-	if err := applyShellHook(currentPath + "/.flk/devenv/shellhook.sh"); err != nil {
+	if err := f.applyShellHook(f.resolve(shellHookPath)); err != nil {
 		return err
 	}
-	if err := applyBuildPhaseScript(currentPath + "/.flk/derivation/build.sh"); err != nil {
+	if err := f.applyBuildPhaseScript(f.resolve(buildScriptPath)); err != nil {
 		return err
 	}
-	if err := applyInstallPhaseScript(currentPath + "/.flk/derivation/install.sh"); err != nil {
+	if err := f.applyInstallPhaseScript(f.resolve(installScriptPath)); err != nil {
 		return err
 	}
-	if err := applyPackagesToFlake(currentPath + "/flake.nix"); err != nil {
+	if err := f.applyPackagesToFlake(); err != nil {
 		return err
 	}
-	return nil
+	// members only hold a devShell and a derivation
+	if f.IsMember() {
+		return nil
+	}
+	if err := f.applyPkgsImport(); err != nil {
+		return err
+	}
+	if err := f.applyImage(); err != nil {
+		return err
+	}
+	if err := f.applyModules(); err != nil {
+		return err
+	}
+	if err := f.applyWorkspace(); err != nil {
+		return err
+	}
+	return f.applyMembers()
 }
 
 // apply phase script
-func applyPhaseScript(scriptPath, phaseName string) error {
+func (f *Flake) applyPhaseScript(scriptPath, phaseName string) error {
 	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -38,71 +67,64 @@ func applyPhaseScript(scriptPath, phaseName string) error {
 		return fmt.Errorf("could not read %s: %w", scriptPath, err)
 	}
 
-	filePath, err := resolveFile("")
-	if err != nil {
-		return fmt.Errorf("could not resolve flake.nix path: %w", err)
-	}
-
-	flakeBytes, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %w", filePath, err)
-	}
-	flake := string(flakeBytes)
+	flake := f.content
 
 	startMarker := phaseName + "Phase = ''"
 	endMarker := "'';"
 
 	startIdx := strings.Index(flake, startMarker)
 	if startIdx == -1 {
-		return insertPhaseBlock(flake, filePath, scriptContent, phaseName, startMarker, endMarker)
+		flake, err = insertPhaseBlock(flake, scriptContent, phaseName, startMarker, endMarker)
+	} else {
+		flake, err = updatePhaseBlock(flake, f.Path, scriptContent, startIdx, startMarker, endMarker)
 	}
-	return updatePhaseBlock(flake, filePath, scriptContent, startIdx, startMarker, endMarker)
+	if err != nil {
+		return err
+	}
+	f.content = flake
+	return nil
 }
 
 // insert a new phase block into mkDerivation
-func insertPhaseBlock(flake, filePath string, scriptContent []byte, phaseName, startMarker, endMarker string) error {
+func insertPhaseBlock(flake string, scriptContent []byte, phaseName, startMarker, endMarker string) (string, error) {
 	blockStart := strings.Index(flake, "pkgs.stdenv.mkDerivation {")
 	if blockStart == -1 {
 		blockStart = strings.Index(flake, "pkgs.mkDerivation {")
 	}
 	if blockStart == -1 {
-		return fmt.Errorf("could not find mkDerivation block in flake.nix; cannot insert %sPhase block", phaseName)
+		return "", newError(KindParse, "could not find mkDerivation block in flake.nix; cannot insert %sPhase block", phaseName)
 	}
 
 	mkStart := blockStart + strings.Index(flake[blockStart:], "{") + 1
 	endIdx := findClosingBrace(flake, mkStart)
 
 	baseIndent := getBaseIndentation(flake, blockStart)
-	attributeIndent := baseIndent + "  "
-	contentIndent := baseIndent + "    "
+	attributeIndent := baseIndent + indentUnit(flake)
+	contentIndent := attributeIndent + indentUnit(flake)
 
 	indentedContent := indentScriptContent(scriptContent, contentIndent)
 	block := "\n" + attributeIndent + startMarker + "\n" + indentedContent + "\n" + attributeIndent + endMarker + "\n"
 
 	newFlake := insertBlockAtPosition(flake, block, endIdx, baseIndent)
 
-	if err := os.WriteFile(filePath, []byte(newFlake), 0644); err != nil {
-		return fmt.Errorf("could not write to %s: %w", filePath, err)
-	}
-
 	if !strings.Contains(newFlake, startMarker) {
-		return fmt.Errorf("failed to insert %sPhase block into mkDerivation", phaseName)
+		return "", fmt.Errorf("failed to insert %sPhase block into mkDerivation", phaseName)
 	}
-	return nil
+	return newFlake, nil
 }
 
 // update existing phase block content
-func updatePhaseBlock(flake, filePath string, scriptContent []byte, startIdx int, startMarker, endMarker string) error {
+func updatePhaseBlock(flake, filePath string, scriptContent []byte, startIdx int, startMarker, endMarker string) (string, error) {
 	rest := flake[startIdx:]
 	endRel := strings.Index(rest, endMarker)
 	if endRel == -1 {
-		return fmt.Errorf("end marker %q not found in %s", endMarker, filePath)
+		return "", newError(KindParse, "end marker %q not found in %s", endMarker, filePath)
 	}
 	endIdx := startIdx + endRel + len(endMarker)
 
 	baseIndent := getBaseIndentationFromPhase(flake, startIdx)
-	attributeIndent := baseIndent + "  "
-	contentIndent := baseIndent + "    "
+	attributeIndent := baseIndent + indentUnit(flake)
+	contentIndent := attributeIndent + indentUnit(flake)
 
 	indentedContent := indentScriptContent(scriptContent, contentIndent)
 	replacement := attributeIndent + startMarker + "\n" + indentedContent + "\n" + attributeIndent + endMarker
@@ -114,12 +136,7 @@ func updatePhaseBlock(flake, filePath string, scriptContent []byte, startIdx int
 		lineStart++
 	}
 
-	newFlake := flake[:lineStart] + replacement + flake[endIdx:]
-
-	if err := os.WriteFile(filePath, []byte(newFlake), 0644); err != nil {
-		return fmt.Errorf("could not write to %s: %w", filePath, err)
-	}
-	return nil
+	return flake[:lineStart] + replacement + flake[endIdx:], nil
 }
 
 // find closing brace for mkDerivation block
@@ -140,7 +157,7 @@ func findClosingBrace(flake string, start int) int {
 	return endIdx
 }
 
-// get base indentation by searching for defaultPackage
+// get base indentation by searching for the derivation attribute
 func getBaseIndentation(flake string, blockStart int) string {
 	searchEnd := blockStart + 50
 	if searchEnd > len(flake) {
@@ -150,7 +167,7 @@ func getBaseIndentation(flake string, blockStart int) string {
 	lines := strings.Split(searchText, "\n")
 	for i := len(lines) - 1; i >= 0; i-- {
 		line := lines[i]
-		if strings.Contains(strings.TrimSpace(line), "defaultPackage =") {
+		if strings.Contains(strings.TrimSpace(line), derivationAttr(flake)+" =") {
 			return getLineIndentation(line)
 		}
 	}
@@ -181,7 +198,7 @@ func getBaseIndentationFromPhase(flake string, startIdx int) string {
 	lines := strings.Split(searchText, "\n")
 	for i := len(lines) - 1; i >= 0; i-- {
 		line := lines[i]
-		if strings.Contains(strings.TrimSpace(line), "defaultPackage =") {
+		if strings.Contains(strings.TrimSpace(line), derivationAttr(flake)+" =") {
 			return getLineIndentation(line)
 		}
 	}
@@ -228,32 +245,28 @@ func insertBlockAtPosition(flake, block string, endIdx int, baseIndent string) s
 }
 
 // apply buildPhase script
-func applyBuildPhaseScript(buildScriptPath string) error {
-	return applyPhaseScript(buildScriptPath, "build")
+func (f *Flake) applyBuildPhaseScript(buildScriptPath string) error {
+	return f.applyPhaseScript(buildScriptPath, "build")
 }
 
 // apply installPhase script
-func applyInstallPhaseScript(installScriptPath string) error {
-	return applyPhaseScript(installScriptPath, "install")
+func (f *Flake) applyInstallPhaseScript(installScriptPath string) error {
+	return f.applyPhaseScript(installScriptPath, "install")
 }
 
 // apply packages to flake.nix
-func applyPackagesToFlake(filePath string) error {
-	// read flake.nix file
-	flakeBytes, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %w", filePath, err)
+func (f *Flake) applyPackagesToFlake() error {
+	flake := f.content
+
+	// shell only flakes have no package.yml
+	if _, err := os.Stat(f.resolve(packageYAMLPath)); os.IsNotExist(err) && !strings.Contains(flake, "pkgs.stdenv.mkDerivation") {
+		return nil
 	}
-	flake := string(flakeBytes)
 
 	// Load package metadata from YAML
-	yamlBytes, err := os.ReadFile(".flk/derivation/package.yml")
+	pkg, err := f.readPackageYAML()
 	if err != nil {
-		return fmt.Errorf("could not read .flk/derivation/package.yml: %w", err)
-	}
-	var pkg PackageYAML
-	if err := yaml.Unmarshal(yamlBytes, &pkg); err != nil {
-		return fmt.Errorf("could not unmarshal .flk/derivation/package.yml: %w", err)
+		return err
 	}
 	pname := pkg.Pname
 	if pname == "" {
@@ -277,64 +290,91 @@ func applyPackagesToFlake(filePath string) error {
 
 	// find buildInputs = [] block
 	buildInputsStart := strings.Index(flake, "buildInputs = [")
+	if buildInputsStart == -1 {
+		return newError(KindParse, "could not find the buildInputs of the derivation in %s", f.Path)
+	}
 	buildInputsEnd := strings.Index(flake[buildInputsStart:], "]")
-	if buildInputsStart != -1 && buildInputsEnd != -1 {
-		indent := ""
-		for i := buildInputsStart - 1; i >= 0; i-- {
-			if flake[i] == '\n' {
-				j := i + 1
-				for j < buildInputsStart && (flake[j] == ' ' || flake[j] == '\t') {
-					indent += string(flake[j])
-					j++
-				}
-				break
+	if buildInputsEnd == -1 {
+		return newError(KindParse, "could not find the end of the buildInputs of the derivation in %s", f.Path)
+	}
+	indent := ""
+	for i := buildInputsStart - 1; i >= 0; i-- {
+		if flake[i] == '\n' {
+			j := i + 1
+			for j < buildInputsStart && (flake[j] == ' ' || flake[j] == '\t') {
+				indent += string(flake[j])
+				j++
 			}
+			break
 		}
-		// set buildInputs indent
-		extraIndent := indent + "  "
-		before := flake[:buildInputsStart+len("buildInputs = [")]
-		after := flake[buildInputsStart+buildInputsEnd:]
-		var pkgsStr string
-		for _, pkgName := range pkg.Packages {
-			pkgsStr += "\n" + extraIndent + "pkgs." + pkgName
-		}
-		if len(pkg.Packages) > 0 {
-			pkgsStr += "\n" + indent
-		}
-		flake = before + pkgsStr + after
 	}
-
-	if err := os.WriteFile(filePath, []byte(flake), 0644); err != nil {
-		return fmt.Errorf("could not write to %s: %w", filePath, err)
+	// set buildInputs indent
+	extraIndent := indent + indentUnit(flake)
+	before := flake[:buildInputsStart+len("buildInputs = [")]
+	after := flake[buildInputsStart+buildInputsEnd:]
+	var pkgsStr string
+	for _, pkgName := range pkg.Packages {
+		pkgsStr += "\n" + extraIndent + "pkgs." + pkgName
 	}
+	if len(pkg.Packages) > 0 {
+		pkgsStr += "\n" + indent
+	}
+	flake = before + pkgsStr + after
+
+	f.content = flake
 	return nil
 }
 
 // structure of .flk/package.yml
 type PackageYAML struct {
-	Pname    string   `yaml:"pname"`
-	Version  string   `yaml:"version"`
-	Src      string   `yaml:"src"`
-	Packages []string `yaml:"packages"`
+	Pname    string   `yaml:"pname" json:"pname"`
+	Version  string   `yaml:"version" json:"version"`
+	Src      string   `yaml:"src" json:"src"`
+	Packages []string `yaml:"packages" json:"packages"`
 }
 
-func ensureShellHookBlock(filePath string) error {
-	// ensure shellHook exists inside mkShell
-	// read flake.nix file
-	flakeBytes, err := os.ReadFile(filePath)
+// writes .flk/derivation/package.yml
+func (f *Flake) writePackageYAML(pkg PackageYAML) error {
+	path := f.resolve(packageYAMLPath)
+	data, err := yaml.Marshal(pkg)
 	if err != nil {
-		if os.IsNotExist(err) {
-			if boilerplateContent == "" {
-				return fmt.Errorf("flake.nix not found and no boilerplate available")
-			}
-			if err := os.WriteFile(filePath, []byte(boilerplateContent), 0644); err != nil {
-				return fmt.Errorf("could not create %s: %w", filePath, err)
-			}
-			return nil
-		}
-		return fmt.Errorf("could not read %s: %w", filePath, err)
+		return fmt.Errorf("could not marshal %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create %s folder: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
+
+// reads .flk/derivation/package.yml
+func (f *Flake) readPackageYAML() (PackageYAML, error) {
+	var pkg PackageYAML
+	path := f.resolve(packageYAMLPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pkg, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &pkg); err != nil {
+		return pkg, newError(KindParse, "could not unmarshal %s: %w", path, err)
 	}
-	flake := string(flakeBytes)
+	return pkg, nil
+}
+
+// pname from .flk/derivation/package.yml, "default" when it is not set
+func (f *Flake) derivationPname() string {
+	pkg, err := f.readPackageYAML()
+	if err != nil || pkg.Pname == "" {
+		return "default"
+	}
+	return pkg.Pname
+}
+
+func (f *Flake) ensureShellHookBlock() error {
+	// ensure shellHook exists inside mkShell
+	flake := f.content
 
 	startMarker := "shellHook = ''"
 	endMarker := "'';"
@@ -343,6 +383,11 @@ func ensureShellHookBlock(filePath string) error {
 		return nil
 	}
 
+	greeting := f.Options.Greeting
+	if greeting == "" {
+		greeting = defaultGreeting
+	}
+
 	mkShellIdx := strings.Index(flake, "default = pkgs.mkShell {")
 	if mkShellIdx == -1 {
 		mkShellIdx = strings.Index(flake, "default=pkgs.mkShell {")
@@ -395,11 +440,9 @@ func ensureShellHookBlock(filePath string) error {
 					indent = baseIndent + "  "
 				}
 
-				shell := fmt.Sprintf("%sshellHook = ''\n%ss  echo \"Development environment loaded\"\n%ss'';\n", indent, indent, indent)
+				shell := fmt.Sprintf("%sshellHook = ''\n%s  %s\n%s'';\n", indent, indent, shellEcho(greeting), indent)
 				newFlake := flake[:insertIdx] + shell + flake[insertIdx:]
-				if err := os.WriteFile(filePath, []byte(newFlake), 0644); err != nil {
-					return fmt.Errorf("could not write to %s: %w", filePath, err)
-				}
+				f.content = newFlake
 				return nil
 			}
 		}
@@ -451,11 +494,9 @@ func ensureShellHookBlock(filePath string) error {
 						indent = baseIndent + "  "
 					}
 
-					shell := fmt.Sprintf("\n%sshellHook = ''\n%ss  echo \"Development environment loaded\"\n%ss'';\n", indent, indent, indent)
+					shell := fmt.Sprintf("\n%sshellHook = ''\n%s  %s\n%s'';\n", indent, indent, shellEcho(greeting), indent)
 					newFlake := flake[:insertIdx] + shell + flake[insertIdx:]
-					if err := os.WriteFile(filePath, []byte(newFlake), 0644); err != nil {
-						return fmt.Errorf("could not write to %s: %w", filePath, err)
-					}
+					f.content = newFlake
 					return nil
 				}
 			}
@@ -463,16 +504,12 @@ func ensureShellHookBlock(filePath string) error {
 	}
 
 	// append at end if needed
-	newShellHook := fmt.Sprintf("%s\n  echo \"Development environment loaded\"\n%s", startMarker, endMarker)
-	flake += "\n" + newShellHook
-	if err := os.WriteFile(filePath, []byte(flake), 0644); err != nil {
-		return fmt.Errorf("could not write to %s: %w", filePath, err)
-	}
-
+	newShellHook := fmt.Sprintf("%s\n  %s\n%s", startMarker, shellEcho(greeting), endMarker)
+	f.content = flake + "\n" + newShellHook
 	return nil
 }
 
-func applyShellHook(shellHookFile string) error {
+func (f *Flake) applyShellHook(shellHookFile string) error {
 	if _, err := os.Stat(shellHookFile); os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -484,29 +521,21 @@ func applyShellHook(shellHookFile string) error {
 		return fmt.Errorf("could not read %s: %w", shellHookFile, err)
 	}
 
-	filePath, err := resolveFile("")
-	if err != nil {
-		return fmt.Errorf("could not resolve flake.nix path: %w", err)
-	}
-
-	flakeBytes, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %w", filePath, err)
-	}
-	flake := string(flakeBytes)
+	flake := f.content
+	filePath := f.Path
 
 	startMarker := "shellHook = ''"
 	endMarker := "'';"
 
 	startIdx := strings.Index(flake, startMarker)
 	if startIdx == -1 {
-		return fmt.Errorf("start marker %q not found in %s", startMarker, filePath)
+		return newError(KindParse, "start marker %q not found in %s", startMarker, filePath)
 	}
 
 	rest := flake[startIdx:]
 	endRel := strings.Index(rest, endMarker)
 	if endRel == -1 {
-		return fmt.Errorf("end marker %q not found in %s", endMarker, filePath)
+		return newError(KindParse, "end marker %q not found in %s", endMarker, filePath)
 	}
 	endIdx := startIdx + endRel + len(endMarker)
 
@@ -527,7 +556,7 @@ func applyShellHook(shellHookFile string) error {
 		}
 	}
 
-	contentIndent := indent + "  "
+	contentIndent := indent + indentUnit(flake)
 
 	rawLines := strings.Split(strings.TrimRight(string(shellHookContent), "\n"), "\n")
 	for i, l := range rawLines {
@@ -540,11 +569,6 @@ func applyShellHook(shellHookFile string) error {
 	indented := strings.Join(rawLines, "\n")
 	replacement := startMarker + "\n" + indented + "\n" + indent + endMarker
 
-	newFlake := flake[:startIdx] + replacement + flake[endIdx:]
-
-	if err := os.WriteFile(filePath, []byte(newFlake), 0644); err != nil {
-		return fmt.Errorf("could not write to %s: %w", filePath, err)
-	}
-
+	f.content = flake[:startIdx] + replacement + flake[endIdx:]
 	return nil
 }
@@ -0,0 +1,64 @@
+package flake
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnsureShellHookBlock(t *testing.T) {
+	shell := "{\n  devShells.default = pkgs.mkShell {\n    packages = [ ];\n  };\n}\n"
+	tests := []struct {
+		greeting string
+		want     string
+	}{
+		{"", "    shellHook = ''\n      echo \"Development environment loaded\"\n    '';\n"},
+		{`Welcome to "$project"`, "    shellHook = ''\n      echo \"Welcome to \\\"\\$project\\\"\"\n    '';\n"},
+	}
+	for _, tt := range tests {
+		f := &Flake{Path: "flake.nix", Options: Options{Greeting: tt.greeting}, content: shell}
+		if err := f.ensureShellHookBlock(); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(f.content, tt.want) {
+			t.Errorf("greeting %q gave\n%s\nwant\n%s", tt.greeting, f.content, tt.want)
+		}
+		if err := checkNixSyntax(f.content); err != nil {
+			t.Error(err)
+		}
+
+		// an existing hook is kept
+		before := f.content
+		if err := f.ensureShellHookBlock(); err != nil || f.content != before {
+			t.Errorf("ensureShellHookBlock changed a flake with a shellHook: %v\n%s", err, f.content)
+		}
+	}
+
+	// a mkShell without a line break after its brace, and no mkShell at all
+	for _, content := range []string{"pkgs.mkShell { packages = [ ]; }", "{ }"} {
+		f := &Flake{Path: "flake.nix", Options: Options{Greeting: "hi"}, content: content}
+		if err := f.ensureShellHookBlock(); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(f.content, `echo "hi"`) || strings.Contains(f.content, "Development environment loaded") {
+			t.Errorf("%q gave a shellHook without the greeting:\n%s", content, f.content)
+		}
+	}
+}
+
+func TestApplyPackagesWithoutBuildInputs(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	if err := f.writePackageYAML(PackageYAML{Pname: "hello", Version: "1.0", Src: "./.", Packages: []string{"go"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.applyPackagesToFlake(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(f.content, "pkgs.go") {
+		t.Errorf("the derivation packages were not applied:\n%s", f.content)
+	}
+
+	f.content = strings.Replace(f.content, "buildInputs = [", "nativeBuildInputs = with pkgs; [", 1)
+	if err := f.applyPackagesToFlake(); KindOf(err) != KindParse {
+		t.Errorf("without buildInputs got %v, want a parse error", err)
+	}
+}
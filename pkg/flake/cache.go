@@ -0,0 +1,207 @@
+package flake
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// NixConfig holds the binary caches declared in the nixConfig block
+type NixConfig struct {
+	Substituters      []string
+	TrustedPublicKeys []string
+	Other             []string // lines flk does not manage, kept verbatim
+}
+
+var quotedRegex = regexp.MustCompile(`"([^"]*)"`)
+
+// finds the nixConfig block, returns -1 if there is none
+func findNixConfigBlock(lines []string) (int, int) {
+	for i, line := range lines {
+		condensed := strings.Join(strings.Fields(line), "")
+		if !strings.HasPrefix(condensed, "nixConfig={") {
+			continue
+		}
+		if strings.HasSuffix(condensed, "};") {
+			return i, i
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if strings.Join(strings.Fields(lines[j]), "") == "};" {
+				return i, j
+			}
+		}
+	}
+	return -1, -1
+}
+
+// parses the lines of a nixConfig block
+func parseNixConfig(block []string) NixConfig {
+	var cfg NixConfig
+	var current *[]string
+
+	for i, line := range block {
+		trim := strings.TrimSpace(line)
+		if i == 0 || (i == len(block)-1 && trim == "};") || trim == "" {
+			continue
+		}
+
+		if current == nil {
+			switch {
+			case strings.HasPrefix(trim, "extra-substituters"):
+				current = &cfg.Substituters
+			case strings.HasPrefix(trim, "extra-trusted-public-keys"):
+				current = &cfg.TrustedPublicKeys
+			default:
+				cfg.Other = append(cfg.Other, trim)
+				continue
+			}
+		}
+
+		for _, m := range quotedRegex.FindAllStringSubmatch(trim, -1) {
+			*current = append(*current, m[1])
+		}
+		if strings.HasSuffix(trim, "];") {
+			current = nil
+		}
+	}
+	return cfg
+}
+
+// renders a nixConfig block at the top level of flake.nix
+func renderNixConfig(cfg NixConfig) []string {
+	if len(cfg.Substituters) == 0 && len(cfg.TrustedPublicKeys) == 0 && len(cfg.Other) == 0 {
+		return nil
+	}
+
+	block := []string{"  nixConfig = {"}
+	for _, l := range cfg.Other {
+		block = append(block, "    "+l)
+	}
+	if len(cfg.Substituters) > 0 {
+		block = append(block, "    extra-substituters = [")
+		for _, s := range cfg.Substituters {
+			block = append(block, "      "+nixString(s))
+		}
+		block = append(block, "    ];")
+	}
+	if len(cfg.TrustedPublicKeys) > 0 {
+		block = append(block, "    extra-trusted-public-keys = [")
+		for _, k := range cfg.TrustedPublicKeys {
+			block = append(block, "      "+nixString(k))
+		}
+		block = append(block, "    ];")
+	}
+	return append(block, "  };")
+}
+
+// NixConfig returns the nixConfig block, empty when there is none
+func (f *Flake) NixConfig() (NixConfig, error) {
+	lines := strings.Split(f.content, "\n")
+	start, end := findNixConfigBlock(lines)
+	if start == -1 {
+		return NixConfig{}, nil
+	}
+	return parseNixConfig(lines[start : end+1]), nil
+}
+
+// replaces the nixConfig block, inserting it after the inputs block if missing
+func (f *Flake) setNixConfig(cfg NixConfig) error {
+	lines := strings.Split(f.content, "\n")
+	block := renderNixConfig(cfg)
+
+	start, end := findNixConfigBlock(lines)
+	if start == -1 {
+		if block == nil {
+			return nil
+		}
+		insertIdx := -1
+		inInputs := false
+		for i, line := range lines {
+			condensed := strings.Join(strings.Fields(line), "")
+			if strings.HasPrefix(condensed, "inputs={") {
+				inInputs = true
+				if !strings.HasSuffix(condensed, "};") {
+					continue
+				}
+			}
+			if inInputs && (condensed == "};" || strings.HasSuffix(condensed, "};")) {
+				insertIdx = i + 1
+				break
+			}
+		}
+		if insertIdx == -1 {
+			return newError(KindParse, "could not find inputs block in %s", f.Path)
+		}
+		block = append([]string{""}, block...)
+		lines = append(lines[:insertIdx], append(block, lines[insertIdx:]...)...)
+	} else {
+		// drop the blank line before a removed block
+		if block == nil && start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+			start--
+		}
+		lines = append(lines[:start], append(block, lines[end+1:]...)...)
+	}
+
+	f.content = strings.Join(lines, "\n")
+	return nil
+}
+
+// AddCache adds a binary cache and optionally its public key
+func (f *Flake) AddCache(cacheURL, key string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	u, err := url.Parse(cacheURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid cache url %q", cacheURL)
+	}
+	if key != "" && !strings.Contains(key, ":") {
+		return fmt.Errorf("invalid public key %q, expected <name>:<base64 key>", key)
+	}
+
+	cfg, err := f.NixConfig()
+	if err != nil {
+		return err
+	}
+	if containsString(cfg.Substituters, cacheURL) && (key == "" || containsString(cfg.TrustedPublicKeys, key)) {
+		return newError(KindAlreadyExists, "cache %s already exists", cacheURL)
+	}
+	if !containsString(cfg.Substituters, cacheURL) {
+		cfg.Substituters = append(cfg.Substituters, cacheURL)
+	}
+	if key != "" && !containsString(cfg.TrustedPublicKeys, key) {
+		cfg.TrustedPublicKeys = append(cfg.TrustedPublicKeys, key)
+	}
+	return f.setNixConfig(cfg)
+}
+
+// RemoveCache removes a binary cache together with the keys named after its host
+func (f *Flake) RemoveCache(cacheURL, key string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	cfg, err := f.NixConfig()
+	if err != nil {
+		return err
+	}
+	if !containsString(cfg.Substituters, cacheURL) {
+		return newError(KindNotFound, "cache %s not found", cacheURL)
+	}
+	cfg.Substituters = removeString(cfg.Substituters, cacheURL)
+
+	host := ""
+	if u, err := url.Parse(cacheURL); err == nil {
+		host = u.Host
+	}
+	var keys []string
+	for _, k := range cfg.TrustedPublicKeys {
+		if k == key || (host != "" && strings.HasPrefix(k, host)) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	cfg.TrustedPublicKeys = keys
+
+	return f.setNixConfig(cfg)
+}
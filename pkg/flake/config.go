@@ -0,0 +1,359 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// project configuration, relative to flake.nix
+const configPath = ".flk/config.yml"
+
+const (
+	defaultNixpkgsURL = "github:NixOS/nixpkgs/nixos-unstable"
+	defaultShellName  = "default"
+	defaultGreeting   = "Development environment loaded"
+)
+
+// ConfigYAML is the structure of config.yml, keys that are not set stay nil
+type ConfigYAML struct {
+	NixpkgsURL  *string  `yaml:"nixpkgsUrl,omitempty"`
+	Systems     []string `yaml:"systems,omitempty"`
+	IndentWidth *int     `yaml:"indentWidth,omitempty"`
+	Derivation  *bool    `yaml:"derivation,omitempty"`
+	Output      *string  `yaml:"output,omitempty"`
+	ShellName   *string  `yaml:"shellName,omitempty"`
+	Greeting    *string  `yaml:"greeting,omitempty"`
+	GitAdd      *bool    `yaml:"gitAdd,omitempty"`
+}
+
+// Config is the configuration in effect after layering the defaults,
+// ~/.config/flk/config.yml, .flk/config.yml and FLK_* environment variables
+type Config struct {
+	NixpkgsURL  string   `json:"nixpkgsUrl"`
+	Systems     []string `json:"systems"`
+	IndentWidth int      `json:"indentWidth"`
+	Derivation  bool     `json:"derivation"`
+	Output      string   `json:"output"`
+	ShellName   string   `json:"shellName"`
+	Greeting    string   `json:"greeting"`
+	GitAdd      bool     `json:"gitAdd"`
+
+	// Sources tells where each key was set, "default", a file or an environment variable
+	Sources map[string]string `json:"-"`
+}
+
+// a config key, set parses a value into a layer and merge copies it to the config when set
+type configKey struct {
+	name  string
+	env   string
+	set   func(l *ConfigYAML, value string) error
+	merge func(c *Config, l ConfigYAML) bool
+	get   func(c Config) string
+}
+
+// config keys in the order they are listed
+var configKeys = []configKey{
+	{
+		name: "nixpkgsUrl",
+		env:  "FLK_NIXPKGS_URL",
+		set:  func(l *ConfigYAML, v string) error { l.NixpkgsURL = &v; return nil },
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.NixpkgsURL != nil {
+				c.NixpkgsURL = *l.NixpkgsURL
+			}
+			return l.NixpkgsURL != nil
+		},
+		get: func(c Config) string { return c.NixpkgsURL },
+	},
+	{
+		name: "systems",
+		env:  "FLK_SYSTEMS",
+		set: func(l *ConfigYAML, v string) error {
+			systems := []string{}
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					if !systemNameRegex.MatchString(s) {
+						return newError(KindConflict, "invalid system %q", s)
+					}
+					systems = append(systems, s)
+				}
+			}
+			l.Systems = systems
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.Systems != nil {
+				c.Systems = l.Systems
+			}
+			return l.Systems != nil
+		},
+		get: func(c Config) string { return strings.Join(c.Systems, ",") },
+	},
+	{
+		name: "indentWidth",
+		env:  "FLK_INDENT_WIDTH",
+		set: func(l *ConfigYAML, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 8 {
+				return newError(KindConflict, "indentWidth must be a number from 1 to 8, got %q", v)
+			}
+			l.IndentWidth = &n
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.IndentWidth != nil {
+				c.IndentWidth = *l.IndentWidth
+			}
+			return l.IndentWidth != nil
+		},
+		get: func(c Config) string { return strconv.Itoa(c.IndentWidth) },
+	},
+	{
+		name: "derivation",
+		env:  "FLK_DERIVATION",
+		set: func(l *ConfigYAML, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return newError(KindConflict, "derivation must be true or false, got %q", v)
+			}
+			l.Derivation = &b
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.Derivation != nil {
+				c.Derivation = *l.Derivation
+			}
+			return l.Derivation != nil
+		},
+		get: func(c Config) string { return strconv.FormatBool(c.Derivation) },
+	},
+	{
+		name: "output",
+		env:  "FLK_OUTPUT",
+		set: func(l *ConfigYAML, v string) error {
+			if v != "text" && v != "json" {
+				return newError(KindConflict, "output must be text or json, got %q", v)
+			}
+			l.Output = &v
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.Output != nil {
+				c.Output = *l.Output
+			}
+			return l.Output != nil
+		},
+		get: func(c Config) string { return c.Output },
+	},
+	{
+		name: "shellName",
+		env:  "FLK_SHELL_NAME",
+		set: func(l *ConfigYAML, v string) error {
+			if !nixIdentRegex.MatchString(v) {
+				return newError(KindConflict, "shellName must be a plain attribute name, got %q", v)
+			}
+			l.ShellName = &v
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.ShellName != nil {
+				c.ShellName = *l.ShellName
+			}
+			return l.ShellName != nil
+		},
+		get: func(c Config) string { return c.ShellName },
+	},
+	{
+		name: "greeting",
+		env:  "FLK_GREETING",
+		set: func(l *ConfigYAML, v string) error {
+			// the greeting ends up in the shellHook string
+			if strings.Contains(v, "${") || strings.Contains(v, "''") || strings.Contains(v, "\n") {
+				return newError(KindConflict, "greeting can not contain ${, '' or a newline")
+			}
+			l.Greeting = &v
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.Greeting != nil {
+				c.Greeting = *l.Greeting
+			}
+			return l.Greeting != nil
+		},
+		get: func(c Config) string { return c.Greeting },
+	},
+	{
+		name: "gitAdd",
+		env:  "FLK_GIT_ADD",
+		set: func(l *ConfigYAML, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return newError(KindConflict, "gitAdd must be true or false, got %q", v)
+			}
+			l.GitAdd = &b
+			return nil
+		},
+		merge: func(c *Config, l ConfigYAML) bool {
+			if l.GitAdd != nil {
+				c.GitAdd = *l.GitAdd
+			}
+			return l.GitAdd != nil
+		},
+		get: func(c Config) string { return strconv.FormatBool(c.GitAdd) },
+	},
+}
+
+func findConfigKey(name string) (configKey, error) {
+	for _, k := range configKeys {
+		if k.name == name {
+			return k, nil
+		}
+	}
+	var names []string
+	for _, k := range configKeys {
+		names = append(names, k.name)
+	}
+	return configKey{}, newError(KindNotFound, "unknown config key %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// ConfigKeys returns the names of the config keys
+func ConfigKeys() []string {
+	names := make([]string, len(configKeys))
+	for i, k := range configKeys {
+		names[i] = k.name
+	}
+	return names
+}
+
+// ConfigEnv returns the environment variable overriding key
+func ConfigEnv(key string) (string, error) {
+	k, err := findConfigKey(key)
+	return k.env, err
+}
+
+// UserConfigPath returns the user configuration, ~/.config/flk/config.yml
+func UserConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "flk", "config.yml"), nil
+}
+
+// ProjectConfigPath returns the project configuration of the flake in dir
+func ProjectConfigPath(dir string) string {
+	return filepath.Join(dir, configPath)
+}
+
+// reads one config file, a missing file sets nothing
+func readConfigYAML(path string) (ConfigYAML, error) {
+	var l ConfigYAML
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return l, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &l); err != nil {
+		return l, newError(KindParse, "could not unmarshal %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// LoadConfig layers the defaults, the user config, the project config of the flake in dir and the environment
+func LoadConfig(dir string) (Config, error) {
+	c := Config{
+		NixpkgsURL:  defaultNixpkgsURL,
+		IndentWidth: 2,
+		Derivation:  true,
+		Output:      "text",
+		ShellName:   defaultShellName,
+		Greeting:    defaultGreeting,
+		Sources:     map[string]string{},
+	}
+	for _, k := range configKeys {
+		c.Sources[k.name] = "default"
+	}
+
+	userPath, err := UserConfigPath()
+	if err != nil {
+		return c, err
+	}
+	for _, path := range []string{userPath, ProjectConfigPath(dir)} {
+		l, err := readConfigYAML(path)
+		if err != nil {
+			return c, err
+		}
+		for _, k := range configKeys {
+			if k.merge(&c, l) {
+				c.Sources[k.name] = path
+			}
+		}
+	}
+
+	for _, k := range configKeys {
+		v, ok := os.LookupEnv(k.env)
+		if !ok {
+			continue
+		}
+		var l ConfigYAML
+		if err := k.set(&l, v); err != nil {
+			return c, fmt.Errorf("%s: %w", k.env, err)
+		}
+		k.merge(&c, l)
+		c.Sources[k.name] = k.env
+	}
+	return c, nil
+}
+
+// Get returns the value of key formatted as flk config set takes it
+func (c Config) Get(key string) (string, error) {
+	k, err := findConfigKey(key)
+	if err != nil {
+		return "", err
+	}
+	return k.get(c), nil
+}
+
+// SetConfig sets key in the config file at path, creating it when missing
+func SetConfig(path, key, value string) error {
+	k, err := findConfigKey(key)
+	if err != nil {
+		return err
+	}
+	l, err := readConfigYAML(path)
+	if err != nil {
+		return err
+	}
+	if err := k.set(&l, value); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Options returns the options new flakes are generated with
+func (c Config) Options() Options {
+	return Options{
+		NixpkgsURL:   c.NixpkgsURL,
+		IndentWidth:  c.IndentWidth,
+		NoDerivation: !c.Derivation,
+		ShellName:    c.ShellName,
+		Greeting:     c.Greeting,
+	}
+}
@@ -0,0 +1,312 @@
+package flake
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// statuses of a Diagnostic
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusError   = "error"
+)
+
+// Diagnostic is the outcome of one check of Diagnose, Fix tells how to resolve a warning or error
+type Diagnostic struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+var (
+	// outputs = { self, nixpkgs, ... }: and outputs = inputs@{ flake-parts, ... }:
+	outputsArgsRegex = regexp.MustCompile(`(?m)^\s*outputs\s*=\s*(?:[a-zA-Z_][a-zA-Z0-9_'-]*\s*@\s*)?\{([^}]*)\}`)
+	pnameRegex       = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.+-]*$`)
+)
+
+// brackets and the bracket closing them
+var nixBrackets = map[byte]byte{'{': '}', '[': ']', '(': ')'}
+
+// Diagnose runs the checks of flk doctor that only need the flake and its .flk folder
+func (f *Flake) Diagnose() []Diagnostic {
+	diags := []Diagnostic{f.diagnoseSyntax()}
+	if !f.IsMember() {
+		diags = append(diags, f.diagnoseOutputsArgs())
+	}
+	diags = append(diags, f.diagnosePackageYAML(), f.diagnoseDuplicatePackages(), f.diagnoseShellHook())
+	if !f.IsMember() {
+		diags = append(diags, f.diagnoseLock())
+	}
+	return diags
+}
+
+// line number of offset i in s
+func lineOf(s string, i int) int {
+	return strings.Count(s[:i], "\n") + 1
+}
+
+// finds unterminated strings and unbalanced brackets, reported with the line they start on
+func checkNixSyntax(s string) error {
+	var open []int
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '"' || strings.HasPrefix(s[i:], "''"):
+			end, err := skipNixString(s, i)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineOf(s, i), err)
+			}
+			i = end
+			continue
+		case c == '#' || strings.HasPrefix(s[i:], "/*"):
+			i = skipNixSpace(s, i)
+			continue
+		case nixBrackets[c] != 0:
+			open = append(open, i)
+		case c == '}' || c == ']' || c == ')':
+			if len(open) == 0 {
+				return fmt.Errorf("line %d: unexpected %q", lineOf(s, i), c)
+			}
+			o := open[len(open)-1]
+			if nixBrackets[s[o]] != c {
+				return fmt.Errorf("line %d: %q does not close %q of line %d", lineOf(s, i), c, s[o], lineOf(s, o))
+			}
+			open = open[:len(open)-1]
+		}
+		i++
+	}
+	if len(open) > 0 {
+		o := open[len(open)-1]
+		return fmt.Errorf("line %d: %q is never closed", lineOf(s, o), s[o])
+	}
+	return nil
+}
+
+func (f *Flake) diagnoseSyntax() Diagnostic {
+	d := Diagnostic{Check: "syntax"}
+	if err := checkNixSyntax(f.content); err != nil {
+		d.Status = StatusError
+		d.Message = fmt.Sprintf("%s does not parse: %v", f.Path, err)
+		d.Fix = "close the string or bracket, a '' string ends at the next '' that is not an escape (''', ''$ or ''\\)"
+		return d
+	}
+	d.Status = StatusOK
+	d.Message = "strings and brackets are balanced"
+	return d
+}
+
+func (f *Flake) diagnoseOutputsArgs() Diagnostic {
+	d := Diagnostic{Check: "outputs"}
+	m := outputsArgsRegex.FindStringSubmatch(f.content)
+	if m == nil {
+		d.Status = StatusWarning
+		d.Message = "could not find the arguments of outputs"
+		d.Fix = "write outputs as a function of an attrset, e.g. outputs = { self, nixpkgs, ... }:"
+		return d
+	}
+	inputs, err := f.Inputs()
+	if err != nil {
+		d.Status = StatusError
+		d.Message = err.Error()
+		d.Fix = "fix the inputs block of flake.nix"
+		return d
+	}
+
+	declared := map[string]bool{}
+	for _, in := range inputs {
+		declared[in.Name] = true
+	}
+	args := map[string]bool{}
+	ellipsis := false
+	var problems []string
+	for _, arg := range strings.Split(m[1], ",") {
+		name, def, _ := strings.Cut(strings.TrimSpace(arg), "?")
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			continue
+		case name == "...":
+			ellipsis = true
+			continue
+		}
+		args[name] = true
+		// self is always passed, and an argument with a default may be missing
+		if name != "self" && strings.TrimSpace(def) == "" && !declared[name] {
+			problems = append(problems, fmt.Sprintf("%s is an argument of outputs but not an input", name))
+		}
+	}
+	if !ellipsis {
+		for _, in := range inputs {
+			if !args[in.Name] {
+				problems = append(problems, fmt.Sprintf("input %s is not an argument of outputs", in.Name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		d.Status = StatusError
+		d.Message = strings.Join(problems, ", ")
+		d.Fix = "declare the missing inputs, or make the outputs arguments match the inputs and end them with ..."
+		return d
+	}
+	d.Status = StatusOK
+	d.Message = "the outputs arguments match the inputs"
+	return d
+}
+
+// validates package.yml: only known fields, a pname, version and src, and packages that are attribute names
+func validatePackageYAML(data []byte) (PackageYAML, []string, error) {
+	var pkg PackageYAML
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&pkg); err != nil && !errors.Is(err, io.EOF) {
+		return pkg, nil, err
+	}
+
+	var problems []string
+	switch {
+	case pkg.Pname == "":
+		problems = append(problems, "pname is missing")
+	case !pnameRegex.MatchString(pkg.Pname):
+		problems = append(problems, fmt.Sprintf("pname %q is not a valid package name", pkg.Pname))
+	}
+	if pkg.Version == "" {
+		problems = append(problems, "version is missing")
+	}
+	if pkg.Src == "" {
+		problems = append(problems, "src is missing")
+	}
+	for _, p := range pkg.Packages {
+		if !pkgAttrRegex.MatchString(p) {
+			problems = append(problems, fmt.Sprintf("package %q is not an attribute of pkgs", p))
+		}
+	}
+	return pkg, problems, nil
+}
+
+func (f *Flake) diagnosePackageYAML() Diagnostic {
+	d := Diagnostic{Check: "package.yml"}
+	data, err := os.ReadFile(f.resolve(packageYAMLPath))
+	if os.IsNotExist(err) {
+		d.Status = StatusOK
+		d.Message = "no " + packageYAMLPath + ", the derivation is not managed by flk"
+		return d
+	} else if err != nil {
+		d.Status = StatusError
+		d.Message = fmt.Sprintf("could not read %s: %v", packageYAMLPath, err)
+		d.Fix = "check the permissions of " + packageYAMLPath
+		return d
+	}
+
+	_, problems, err := validatePackageYAML(data)
+	if err != nil {
+		d.Status = StatusError
+		// yaml lists each error on a line of its own
+		d.Message = fmt.Sprintf("%s is invalid: %s", packageYAMLPath, strings.Join(strings.Fields(err.Error()), " "))
+		d.Fix = "only pname, version, src and a list of packages are allowed"
+		return d
+	}
+	if len(problems) > 0 {
+		d.Status = StatusError
+		d.Message = fmt.Sprintf("%s: %s", packageYAMLPath, strings.Join(problems, ", "))
+		d.Fix = "e.g. pname: hello, version: \"0.1\", src: ./. and packages: [ go ]"
+		return d
+	}
+	d.Status = StatusOK
+	d.Message = packageYAMLPath + " is valid"
+	return d
+}
+
+func (f *Flake) diagnoseDuplicatePackages() Diagnostic {
+	d := Diagnostic{Check: "duplicates"}
+	shellPkgs, err := f.getPackages()
+	if err != nil {
+		d.Status = StatusError
+		d.Message = err.Error()
+		d.Fix = "fix the packages list of the devShell"
+		return d
+	}
+	drvPkgs, err := f.getPackagesFromPackageYML()
+	if err != nil {
+		// no or broken package.yml, reported by its own check
+		d.Status = StatusOK
+		d.Message = "no derivation packages to compare"
+		return d
+	}
+
+	inShell := map[string]bool{}
+	for _, p := range shellPkgs {
+		inShell[strings.TrimPrefix(p, "pkgs.")] = true
+	}
+	var both []string
+	for _, p := range drvPkgs {
+		if name := strings.TrimPrefix(p, "pkgs."); inShell[name] {
+			both = append(both, name)
+		}
+	}
+	if len(both) > 0 {
+		sort.Strings(both)
+		d.Status = StatusWarning
+		d.Message = fmt.Sprintf("in both the devShell and the derivation: %s", strings.Join(both, ", "))
+		d.Fix = "keep packages in " + packageYAMLPath + " only when the build needs them, and remove tools the build doesn't use"
+		return d
+	}
+	d.Status = StatusOK
+	d.Message = "no package is in both the devShell and the derivation"
+	return d
+}
+
+func (f *Flake) diagnoseShellHook() Diagnostic {
+	d := Diagnostic{Check: "shellhook"}
+	hook, err := os.ReadFile(f.resolve(shellHookPath))
+	if os.IsNotExist(err) {
+		shells, err := f.DevShells()
+		if err != nil || len(shells) == 0 {
+			d.Status = StatusOK
+			d.Message = "no shellHook"
+			return d
+		}
+		hook = []byte(shells[0].ShellHook)
+	} else if err != nil {
+		d.Status = StatusError
+		d.Message = fmt.Sprintf("could not read %s: %v", shellHookPath, err)
+		d.Fix = "check the permissions of " + shellHookPath
+		return d
+	}
+
+	for _, line := range strings.Split(string(hook), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			d.Status = StatusOK
+			d.Message = "the shellHook runs commands"
+			return d
+		}
+	}
+	d.Status = StatusWarning
+	d.Message = "the shellHook is empty"
+	d.Fix = "write the commands to run when entering the shell to " + shellHookPath + " and run flk flake apply"
+	return d
+}
+
+func (f *Flake) diagnoseLock() Diagnostic {
+	d := Diagnostic{Check: "lock"}
+	if _, err := os.Stat(f.resolve("flake.lock")); os.IsNotExist(err) {
+		d.Status = StatusWarning
+		d.Message = "flake.lock is missing, inputs are not pinned"
+		d.Fix = "run flk lock update and commit flake.lock"
+		return d
+	}
+	d.Status = StatusOK
+	d.Message = "flake.lock pins the inputs"
+	return d
+}
@@ -0,0 +1,79 @@
+package flake
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	if err := f.AddPackage("jq", ""); err != nil {
+		t.Fatal(err)
+	}
+	status := func() map[string]Diagnostic {
+		diags := map[string]Diagnostic{}
+		for _, d := range f.Diagnose() {
+			diags[d.Check] = d
+		}
+		return diags
+	}
+
+	for check, d := range status() {
+		want := StatusOK
+		if check == "lock" {
+			want = StatusWarning
+		}
+		if d.Status != want {
+			t.Errorf("%s of a new flake is %s, want %s: %s", check, d.Status, want, d.Message)
+		}
+	}
+
+	// jq in the derivation too, an unknown field and an empty shellHook
+	if err := os.WriteFile(f.resolve(packageYAMLPath), []byte("pname: hello\nversion: \"0.1\"\nsrc: ./.\npackages: [ jq ]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.resolve(shellHookPath), []byte("# nothing yet\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diags := status()
+	if d := diags["duplicates"]; d.Status != StatusWarning || !strings.Contains(d.Message, "jq") {
+		t.Errorf("duplicates: %+v", d)
+	}
+	if d := diags["shellhook"]; d.Status != StatusWarning {
+		t.Errorf("shellhook: %+v", d)
+	}
+	if err := os.WriteFile(f.resolve(packageYAMLPath), []byte("pname: hello\nversion: \"0.1\"\nsrcc: ./.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d := status()["package.yml"]; d.Status != StatusError || !strings.Contains(d.Message, "srcc") || strings.Contains(d.Message, "\n") {
+		t.Errorf("package.yml: %+v", d)
+	}
+
+	// an outputs argument that is not an input, and an unterminated string
+	f.content = strings.Replace(f.content, "flake-utils, ... }:", "flake-utils, devenv, ... }:", 1)
+	if d := status()["outputs"]; d.Status != StatusError || !strings.Contains(d.Message, "devenv is an argument of outputs but not an input") {
+		t.Errorf("outputs: %+v", d)
+	}
+	f.content = strings.Replace(f.content, "shellHook = ''", "shellHook = \"", 1)
+	if d := status()["syntax"]; d.Status != StatusError || !strings.Contains(d.Message, "unterminated") {
+		t.Errorf("syntax: %+v", d)
+	}
+}
+
+func TestCheckNixSyntax(t *testing.T) {
+	// escapes, strings and comments don't count as brackets
+	if err := checkNixSyntax("{\n  s = ''\n    it''s ''${not} interpolated '''\n  '';\n  t = \"a } b\"; /* } */\n}  # }\n"); err != nil {
+		t.Error(err)
+	}
+	for src, want := range map[string]string{
+		"{\n  s = ''\n    never closed\n}": "line 2: unterminated '' string",
+		"{\n  a = [ 1 2 );\n}":             `line 2: ')' does not close '[' of line 2`,
+		"{ a = 1; }}":                      "line 1: unexpected '}'",
+		"{\n  a = {\n}":                    "line 1: '{' is never closed",
+	} {
+		if err := checkNixSyntax(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("checking %q gave %v, want %s", src, err, want)
+		}
+	}
+}
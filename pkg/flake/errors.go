@@ -0,0 +1,67 @@
+package flake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kind tells errors apart, e.g. a missing package from a flake that could not be parsed
+type Kind string
+
+const (
+	KindError         Kind = "error"
+	KindNotFound      Kind = "not-found"
+	KindAlreadyExists Kind = "already-exists"
+	KindParse         Kind = "parse-error"
+	KindConflict      Kind = "conflict"
+	KindIO            Kind = "io-error"
+	KindUsage         Kind = "usage"
+)
+
+// Error is an error with a kind
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// creates an error of the given kind, %w keeps the wrapped error
+func newError(kind Kind, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+// KindOf returns the kind of err, classifying file system and decoding errors that were not given one
+func KindOf(err error) Kind {
+	var fe *Error
+	if errors.As(err, &fe) {
+		return fe.Kind
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return KindNotFound
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return KindAlreadyExists
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return KindIO
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var yamlErr *yaml.TypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.As(err, &yamlErr) {
+		return KindParse
+	}
+	return KindError
+}
@@ -0,0 +1,129 @@
+// Package flake edits flk managed flake.nix files and the .flk folder next to them.
+//
+// A Flake is loaded into memory, changed through its methods and written back
+// with Save. Methods return errors of type *Error, KindOf tells them apart.
+package flake
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Flake is a flake.nix held in memory
+type Flake struct {
+	// Path of flake.nix
+	Path string
+	// Options used when generating parts of the flake
+	Options Options
+
+	content string
+}
+
+// Options change how flakes are generated, the zero value keeps the defaults
+type Options struct {
+	// NixpkgsURL is used when the flake has no nixpkgs input yet
+	NixpkgsURL string
+	// IndentWidth is the number of spaces per level
+	IndentWidth int
+	// NoDerivation leaves the derivation out of GenerateFlk
+	NoDerivation bool
+	// ShellName is the name of the devShell of new flakes
+	ShellName string
+	// Greeting is echoed by the shellHook of new flakes
+	Greeting string
+}
+
+// New returns a flake with the boilerplate of style, "" for flake-utils. It is not written until Save.
+func New(path, style string) (*Flake, error) {
+	return NewWithOptions(path, style, Options{})
+}
+
+// NewWithOptions returns a flake like New, with the nixpkgs URL, shell name and greeting of opts
+func NewWithOptions(path, style string, opts Options) (*Flake, error) {
+	boilerplate, err := boilerplateForStyle(style)
+	if err != nil {
+		return nil, err
+	}
+	if opts.NixpkgsURL != "" {
+		boilerplate = strings.Replace(boilerplate, nixString(defaultNixpkgsURL), nixString(opts.NixpkgsURL), 1)
+	}
+	if opts.ShellName != "" {
+		boilerplate = strings.Replace(boilerplate, "default = pkgs.mkShell {", nixAttrName(opts.ShellName)+" = pkgs.mkShell {", 1)
+	}
+	if opts.Greeting != "" {
+		boilerplate = strings.Replace(boilerplate, `echo "`+defaultGreeting+`"`, shellEcho(opts.Greeting), 1)
+	}
+	return &Flake{Path: path, Options: opts, content: boilerplate}, nil
+}
+
+// Load reads the flake at path
+func Load(path string) (*Flake, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return &Flake{Path: path, content: string(content)}, nil
+}
+
+// Save writes the flake back to its path
+func (f *Flake) Save() error {
+	if err := os.WriteFile(f.Path, []byte(f.content), 0644); err != nil {
+		return fmt.Errorf("could not write to %s: %w", f.Path, err)
+	}
+	return nil
+}
+
+// String returns the content of flake.nix
+func (f *Flake) String() string {
+	return f.content
+}
+
+// Dir returns the directory holding flake.nix and its .flk folder
+func (f *Flake) Dir() string {
+	return filepath.Dir(f.Path)
+}
+
+// resolves a path relative to flake.nix, such as .flk/image.yml
+func (f *Flake) resolve(rel string) string {
+	return filepath.Join(f.Dir(), rel)
+}
+
+// Style returns the output style, StyleFlakeUtils or StyleFlakeParts
+func (f *Flake) Style() string {
+	return flakeStyle(f.content)
+}
+
+// Reindent changes the indentation of the flake from two spaces per level to Options.IndentWidth
+func (f *Flake) Reindent() {
+	width := f.Options.IndentWidth
+	if width <= 0 || width == 2 {
+		return
+	}
+	lines := strings.Split(f.content, "\n")
+	for i, line := range lines {
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		lines[i] = strings.Repeat(" ", n/2*width+n%2) + line[n:]
+	}
+	f.content = strings.Join(lines, "\n")
+}
+
+// the indentation of one level, taken from the inputs of the flake
+func indentUnit(flake string) string {
+	for _, line := range strings.Split(flake, "\n") {
+		trim := strings.TrimSpace(line)
+		if strings.HasPrefix(trim, "inputs") || strings.HasPrefix(trim, "outputs") {
+			if indent := getLineIndentation(line); indent != "" {
+				return indent
+			}
+		}
+	}
+	return "  "
+}
+
+// a shell echo of s for the shellHook
+func shellEcho(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`)
+	return `echo "` + r.Replace(s) + `"`
+}
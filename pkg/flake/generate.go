@@ -0,0 +1,166 @@
+package flake
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GenerateFlk makes a .flk folder from the shellHook and packages of the flake, and adds the derivation unless Options.NoDerivation is set
+func (f *Flake) GenerateFlk() error {
+	shellHookLines, shErr := getLinesBetween(f.content, "shellHook = ''", "'';")
+
+	pkgs, pkErr := f.getPackages()
+
+	if shErr != nil && (pkErr != nil || len(pkgs) == 0) {
+		return nil
+	}
+
+	// ensure .flk exists
+	if err := os.MkdirAll(f.resolve(".flk"), 0755); err != nil {
+		return fmt.Errorf("could not create .flk folder: %w", err)
+	}
+	// ensure .flk/devenv exists
+	if err := os.MkdirAll(f.resolve(".flk/devenv"), 0755); err != nil {
+		return fmt.Errorf("could not create .flk/devenv folder: %w", err)
+	}
+
+	// If shellHook was found, write it out
+	if shErr == nil {
+		outf, err := os.Create(f.resolve(".flk/devenv/shellhook.sh"))
+		if err != nil {
+			return fmt.Errorf("could not create .flk/devenv/shellhook.sh: %w", err)
+		}
+		defer outf.Close()
+
+		for _, line := range shellHookLines {
+			if _, err := outf.WriteString(line + "\n"); err != nil {
+				return fmt.Errorf("could not write to .flk/devenv/shellhook.sh: %w", err)
+			}
+		}
+	}
+
+	if f.Options.NoDerivation {
+		return nil
+	}
+	if pkErr != nil {
+		pkgs = nil
+	}
+	return f.generateDerivation(pkgs)
+}
+
+// writes .flk/derivation with pkgs as build inputs and adds the derivation and its phases to the flake
+func (f *Flake) generateDerivation(pkgs []string) error {
+	flakePath := f.Path
+
+	// make .flk/derivation
+	if err := os.MkdirAll(f.resolve(".flk/derivation"), 0755); err != nil {
+		return fmt.Errorf("could not create .flk/derivation folder: %w", err)
+	}
+
+	// Write a YAML file with pname, version, src, and packages fields
+	yf, err := os.Create(f.resolve(".flk/derivation/package.yml"))
+	if err != nil {
+		return fmt.Errorf("could not create .flk/derivation/package.yml: %w", err)
+	}
+	defer yf.Close()
+
+	pname := "default"
+	version := "0.1"
+	src := "./."
+
+	if _, err := yf.WriteString(fmt.Sprintf("pname: %s\nversion: %s\nsrc: %s\npackages:\n", pname, version, src)); err != nil {
+		return fmt.Errorf("could not write to .flk/derivation/package.yml: %w", err)
+	}
+	if len(pkgs) > 0 {
+		for _, p := range pkgs {
+			if _, err := yf.WriteString("  - " + strings.TrimPrefix(p, "pkgs.") + "\n"); err != nil {
+				return fmt.Errorf("could not write to .flk/package.yml: %w", err)
+			}
+		}
+	}
+
+	// Ensure mkDerivation block exists
+	if err := f.ensureDerivation(); err != nil {
+		return fmt.Errorf("could not ensure derivation in %s: %w", flakePath, err)
+	}
+
+	// Make a build.sh in /derivation
+	bf, err := os.Create(f.resolve(".flk/derivation/build.sh"))
+	if err != nil {
+		return fmt.Errorf("could not create .flk/derivation/build.sh: %w", err)
+	}
+	defer bf.Close()
+	if err := f.applyBuildPhaseScript(bf.Name()); err != nil {
+		return fmt.Errorf("could not apply build phase script: %w", err)
+	}
+
+	// Make an install.sh in /derivation
+	instf, err := os.Create(f.resolve(".flk/derivation/install.sh"))
+	if err != nil {
+		return fmt.Errorf("could not create .flk/derivation/install.sh: %w", err)
+	}
+	defer instf.Close()
+
+	// Write default install.sh content
+	defaultInstallContent := `
+
+	`
+	if _, err := instf.WriteString(defaultInstallContent); err != nil {
+		return fmt.Errorf("could not write to .flk/derivation/install.sh: %w", err)
+	}
+
+	if err := f.applyInstallPhaseScript(instf.Name()); err != nil {
+		return fmt.Errorf("could not apply install phase script: %w", err)
+	}
+
+	return nil
+}
+
+// returns the trimmed lines between start and end
+func getLinesBetween(content, start, end string) ([]string, error) {
+	trimmedStart := strings.TrimSpace(start)
+	trimmedEnd := strings.TrimSpace(end)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	var lines []string
+	capturing := false
+
+	for scanner.Scan() {
+		raw := scanner.Text()
+		t := strings.TrimSpace(raw)
+
+		if !capturing {
+			if strings.Contains(t, trimmedStart) {
+				capturing = true
+				if idx := strings.Index(t, trimmedStart); idx != -1 {
+					rest := strings.TrimSpace(t[idx+len(trimmedStart):])
+					if rest != "" {
+						lines = append(lines, rest)
+					}
+				}
+				continue
+			}
+		} else {
+			if strings.Contains(t, trimmedEnd) {
+				if idx := strings.Index(t, trimmedEnd); idx > 0 {
+					prefix := strings.TrimSpace(t[:idx])
+					if prefix != "" {
+						lines = append(lines, prefix)
+					}
+				}
+				return lines, nil
+			}
+			if t != "" {
+				lines = append(lines, t)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning file: %w", err)
+	}
+
+	return nil, fmt.Errorf("end marker not found")
+}
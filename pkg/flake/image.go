@@ -0,0 +1,192 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const imageConfigPath = ".flk/image.yml"
+
+// structure of .flk/image.yml
+type ImageYAML struct {
+	Name       string            `yaml:"name"`
+	Tag        string            `yaml:"tag"`
+	Entrypoint []string          `yaml:"entrypoint"`
+	Ports      []string          `yaml:"ports,omitempty"`
+	Env        map[string]string `yaml:"env,omitempty"`
+	Contents   []string          `yaml:"contents,omitempty"`
+}
+
+// reads .flk/image.yml, ok is false when the image is not enabled
+func (f *Flake) readImageConfig() (ImageYAML, bool, error) {
+	var img ImageYAML
+	data, err := os.ReadFile(f.resolve(imageConfigPath))
+	if os.IsNotExist(err) {
+		return img, false, nil
+	} else if err != nil {
+		return img, false, fmt.Errorf("could not read %s: %w", imageConfigPath, err)
+	}
+	if err := yaml.Unmarshal(data, &img); err != nil {
+		return img, false, newError(KindParse, "could not unmarshal %s: %w", imageConfigPath, err)
+	}
+	return img, true, nil
+}
+
+func (f *Flake) writeImageConfig(img ImageYAML) error {
+	data, err := yaml.Marshal(img)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s: %w", imageConfigPath, err)
+	}
+	if err := os.MkdirAll(f.resolve(".flk"), 0755); err != nil {
+		return fmt.Errorf("could not create .flk folder: %w", err)
+	}
+	if err := os.WriteFile(f.resolve(imageConfigPath), data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", imageConfigPath, err)
+	}
+	return nil
+}
+
+// EnableImage creates .flk/image.yml from package.yml and renders packages.image
+func (f *Flake) EnableImage() error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	if _, ok, err := f.readImageConfig(); err != nil {
+		return err
+	} else if !ok {
+		pname := f.derivationPname()
+		img := ImageYAML{
+			Name:       pname,
+			Tag:        "latest",
+			Entrypoint: []string{"bin/" + pname},
+		}
+		if err := f.writeImageConfig(img); err != nil {
+			return err
+		}
+	}
+	return f.applyImage()
+}
+
+// expression referring to the flk derivation from the per-system outputs
+func derivationRef(flake string) string {
+	if flakeStyle(flake) == StyleFlakeParts {
+		return "inputs.self.packages.${system}.default"
+	}
+	return "self.defaultPackage.${system}"
+}
+
+// renders the packages.image block, relative entrypoint paths point into the derivation
+func renderImage(img ImageYAML, drv, indent string) []string {
+	var contents []string
+	contents = append(contents, drv)
+	for _, c := range img.Contents {
+		contents = append(contents, "pkgs."+strings.TrimPrefix(c, "pkgs."))
+	}
+
+	var entrypoint []string
+	for _, e := range img.Entrypoint {
+		if strings.HasPrefix(e, "/") {
+			entrypoint = append(entrypoint, nixString(e))
+			continue
+		}
+		quoted := nixString("/" + e)
+		entrypoint = append(entrypoint, `"${`+drv+`}`+quoted[1:])
+	}
+
+	tag := img.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+
+	block := []string{
+		indent + "packages.image = pkgs.dockerTools.buildLayeredImage {",
+		indent + "  name = " + nixString(img.Name) + ";",
+		indent + "  tag = " + nixString(tag) + ";",
+		indent + "  contents = [ " + strings.Join(contents, " ") + " ];",
+		indent + "  config = {",
+	}
+	if len(entrypoint) > 0 {
+		block = append(block, indent+"    Entrypoint = [ "+strings.Join(entrypoint, " ")+" ];")
+	}
+	if len(img.Ports) > 0 {
+		var ports []string
+		for _, p := range img.Ports {
+			if !strings.Contains(p, "/") {
+				p += "/tcp"
+			}
+			ports = append(ports, nixString(p)+" = { };")
+		}
+		block = append(block, indent+"    ExposedPorts = { "+strings.Join(ports, " ")+" };")
+	}
+	if len(img.Env) > 0 {
+		keys := make([]string, 0, len(img.Env))
+		for k := range img.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var env []string
+		for _, k := range keys {
+			env = append(env, nixString(k+"="+img.Env[k]))
+		}
+		block = append(block, indent+"    Env = [ "+strings.Join(env, " ")+" ];")
+	}
+	block = append(block, indent+"  };", indent+"};")
+	return block
+}
+
+// finds the lines of a block opened by a line starting with marker and closed by `};` at the same indentation
+func findAttrBlock(lines []string, marker string) (int, int) {
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), marker) {
+			continue
+		}
+		indent := getLineIndentation(line)
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == "};" && getLineIndentation(lines[j]) == indent {
+				return i, j
+			}
+		}
+		return -1, -1
+	}
+	return -1, -1
+}
+
+// renders .flk/image.yml into packages.image, removing the output once the file is gone
+func (f *Flake) applyImage() error {
+	img, enabled, err := f.readImageConfig()
+	if err != nil {
+		return err
+	}
+
+	filePath := f.Path
+	flake := f.content
+	lines := strings.Split(flake, "\n")
+
+	start, end := findAttrBlock(lines, "packages.image =")
+	if start != -1 {
+		// drop the old block and the blank line before it
+		if start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+			start--
+		}
+		lines = append(lines[:start], lines[end+1:]...)
+	}
+
+	if enabled {
+		if img.Name == "" {
+			return fmt.Errorf("%s has no name", imageConfigPath)
+		}
+		drvStart, drvEnd := findAttrBlock(lines, derivationAttr(flake)+" = pkgs.stdenv.mkDerivation {")
+		if drvStart == -1 {
+			return newError(KindNotFound, "could not find the flk derivation in %s, the image is built from it", filePath)
+		}
+		block := append([]string{""}, renderImage(img, derivationRef(flake), getLineIndentation(lines[drvStart]))...)
+		lines = append(lines[:drvEnd+1], append(block, lines[drvEnd+1:]...)...)
+	}
+
+	f.content = strings.Join(lines, "\n")
+	return nil
+}
@@ -0,0 +1,293 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Import is what could be translated from a legacy shell.nix, default.nix or another tool's config
+type Import struct {
+	Packages     []string // nixpkgs attributes, without the pkgs. prefix
+	WithPkgs     bool     // package lists were written `with pkgs;`
+	ShellHook    string
+	Env          []nixAttr // plain values passed to mkShell as environment
+	Derivation   *PackageYAML
+	BuildPhase   string
+	InstallPhase string
+	Warnings     []string
+}
+
+var (
+	pkgAttrRegex    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_+-]*(\.[a-zA-Z_][a-zA-Z0-9_+-]*)*$`)
+	plainValueRegex = regexp.MustCompile(`^(-?[0-9.]+|true|false|null)$`)
+)
+
+// mkShell attributes that hold packages
+var packageAttrs = map[string]bool{
+	"packages":          true,
+	"buildInputs":       true,
+	"nativeBuildInputs": true,
+}
+
+// translates the package list expr, warning about entries that aren't nixpkgs attributes
+func (l *Import) translatePackages(expr string, withPkgs bool, into *[]string) {
+	expr = withPrefixRegex.ReplaceAllString(strings.TrimSpace(expr), "")
+	items, ok := parseNixList(expr)
+	if !ok {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate package list %q", expr))
+		return
+	}
+	for _, item := range items {
+		switch {
+		case strings.HasPrefix(item, "pkgs.") && pkgAttrRegex.MatchString(item):
+			*into = append(*into, strings.TrimPrefix(item, "pkgs."))
+		case withPkgs && pkgAttrRegex.MatchString(item):
+			*into = append(*into, item)
+			l.WithPkgs = true
+		default:
+			l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate package %s", item))
+		}
+	}
+}
+
+// parses a legacy nix file built around mkShell and/or mkDerivation
+func parseLegacyNix(src string) (*Import, error) {
+	l := &Import{}
+	withPkgs := strings.Contains(src, "with pkgs;")
+
+	for _, pin := range []string{"fetchTarball", "fetchFromGitHub", "fetchGit"} {
+		if strings.Contains(src, pin) {
+			l.Warnings = append(l.Warnings, "pinned nixpkgs is not carried over, set inputs.nixpkgs.url in flake.nix by hand")
+			break
+		}
+	}
+
+	shellBody, hasShell := findCallAttrs(src, "mkShell")
+	drvBody, hasDrv := findCallAttrs(src, "mkDerivation")
+	if !hasShell && !hasDrv {
+		return nil, newError(KindParse, "could not find a mkShell or mkDerivation call")
+	}
+
+	if hasShell {
+		attrs, warnings, err := parseNixAttrs(shellBody)
+		if err != nil {
+			return nil, newError(KindParse, "could not parse mkShell: %w", err)
+		}
+		l.Warnings = append(l.Warnings, warnings...)
+
+		for _, a := range attrs {
+			switch {
+			case packageAttrs[a.Name]:
+				l.translatePackages(a.Value, withPkgs, &l.Packages)
+			case a.Name == "shellHook":
+				hook, ok := parseNixScript(a.Value)
+				if !ok {
+					l.Warnings = append(l.Warnings, "could not translate shellHook, it is not a plain string")
+					continue
+				}
+				l.ShellHook = hook
+			case a.Name == "name":
+				// flakes name the shell themselves
+			case isPlainNixValue(a.Value):
+				l.Env = append(l.Env, a)
+			default:
+				l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate mkShell attribute %s", a.Name))
+			}
+		}
+	}
+
+	if hasDrv {
+		attrs, warnings, err := parseNixAttrs(drvBody)
+		if err != nil {
+			return nil, newError(KindParse, "could not parse mkDerivation: %w", err)
+		}
+		l.Warnings = append(l.Warnings, warnings...)
+
+		drv := &PackageYAML{}
+		for _, a := range attrs {
+			switch {
+			case packageAttrs[a.Name]:
+				l.translatePackages(a.Value, withPkgs, &drv.Packages)
+			case a.Name == "pname" || a.Name == "version" || a.Name == "name":
+				s, ok := parseNixString(a.Value)
+				if !ok {
+					l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate %s, it is not a plain string", a.Name))
+					continue
+				}
+				switch a.Name {
+				case "pname":
+					drv.Pname = s
+				case "version":
+					drv.Version = s
+				case "name":
+					if drv.Pname == "" {
+						drv.Pname = s
+					}
+				}
+			case a.Name == "src":
+				drv.Src = a.Value
+			case a.Name == "buildPhase" || a.Name == "installPhase":
+				s, ok := parseNixScript(a.Value)
+				if !ok {
+					l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate %s, it is not a plain string", a.Name))
+					continue
+				}
+				if a.Name == "buildPhase" {
+					l.BuildPhase = s
+				} else {
+					l.InstallPhase = s
+				}
+			default:
+				l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate mkDerivation attribute %s", a.Name))
+			}
+		}
+		l.Derivation = drv
+	}
+
+	return l, nil
+}
+
+func isPlainNixValue(expr string) bool {
+	if _, ok := parseNixString(expr); ok && !strings.Contains(expr, "${") {
+		return true
+	}
+	return plainValueRegex.MatchString(expr)
+}
+
+// writes the env attributes at the top of the mkShell block
+func (f *Flake) insertShellAttrs(attrs []nixAttr) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	lines := strings.Split(f.content, "\n")
+
+	for i, line := range lines {
+		if !strings.Contains(line, "pkgs.mkShell {") {
+			continue
+		}
+		indent := getLineIndentation(line) + "  "
+		var block []string
+		for _, a := range attrs {
+			block = append(block, fmt.Sprintf("%s%s = %s;", indent, nixAttrName(a.Name), a.Value))
+		}
+		lines = append(lines[:i+1], append(block, lines[i+1:]...)...)
+		f.content = strings.Join(lines, "\n")
+		return nil
+	}
+	return newError(KindParse, "could not find mkShell block in %s", f.Path)
+}
+
+// ImportLegacy generates the flake at target and .flk from a legacy shell.nix or default.nix, using opts for the
+// new flake. Package lists written with `with pkgs;` stay that way.
+func ImportLegacy(legacyPath, target string, opts Options) (*Import, error) {
+	src, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", legacyPath, err)
+	}
+	l, err := parseLegacyNix(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("could not import %s: %w", legacyPath, err)
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		return nil, newError(KindAlreadyExists, "%s already exists", target)
+	}
+	if err := writeImport(l, target, opts); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// writes an import into target, creating the flake and .flk with opts when target doesn't exist yet
+func writeImport(l *Import, target string, opts Options) error {
+	_, statErr := os.Stat(target)
+	fresh := os.IsNotExist(statErr)
+	var f *Flake
+	var err error
+	if fresh {
+		f, err = NewWithOptions(target, "", opts)
+	} else {
+		f, err = Load(target)
+	}
+	if err != nil {
+		return err
+	}
+	f.Options = opts
+	if l.Derivation != nil && opts.NoDerivation {
+		l.Warnings = append(l.Warnings, "mkDerivation is not imported, derivations are turned off in the config")
+		l.Derivation = nil
+	}
+
+	if fresh && l.WithPkgs && len(l.Packages) > 0 {
+		// keep the list style of the imported file
+		f.content = packagesListRegex.ReplaceAllLiteralString(f.content, "packages = with pkgs; [")
+	}
+	for _, p := range l.Packages {
+		// packages the flake already has are fine when merging
+		if err := f.AddPackage(p, ""); KindOf(err) == KindAlreadyExists {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("skipped %s, the flake already has it", p))
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := f.insertShellAttrs(l.Env); err != nil {
+		return err
+	}
+
+	if fresh {
+		// only imports with a mkDerivation get a derivation, GenerateFlk would add a placeholder one
+		f.Options.NoDerivation = l.Derivation == nil
+		err := f.GenerateFlk()
+		f.Options.NoDerivation = opts.NoDerivation
+		if err != nil {
+			return err
+		}
+	}
+
+	if l.ShellHook != "" {
+		hook := l.ShellHook + "\n"
+		// keep the existing hook of a flake we are merging into
+		if existing, err := os.ReadFile(f.resolve(shellHookPath)); err == nil && !fresh {
+			hook = strings.TrimRight(string(existing), "\n") + "\n" + hook
+		}
+		if err := os.MkdirAll(f.resolve(".flk/devenv"), 0755); err != nil {
+			return fmt.Errorf("could not create .flk/devenv folder: %w", err)
+		}
+		if err := os.WriteFile(f.resolve(shellHookPath), []byte(hook), 0644); err != nil {
+			return fmt.Errorf("could not write .flk/devenv/shellhook.sh: %w", err)
+		}
+	}
+	if l.Derivation != nil {
+		if err := f.writePackageYAML(*l.Derivation); err != nil {
+			return err
+		}
+		for script, content := range map[string]string{
+			buildScriptPath:   l.BuildPhase,
+			installScriptPath: l.InstallPhase,
+		} {
+			if err := os.WriteFile(f.resolve(script), []byte(content+"\n"), 0644); err != nil {
+				return fmt.Errorf("could not write %s: %w", script, err)
+			}
+		}
+	}
+
+	if _, err := os.Stat(f.resolve(packageYAMLPath)); err == nil {
+		if err := f.Apply(); err != nil {
+			return err
+		}
+	} else {
+		// flake without a derivation, only the shell hook applies
+		if err := f.ensureShellHookBlock(); err != nil {
+			return err
+		}
+		if err := f.applyShellHook(f.resolve(shellHookPath)); err != nil {
+			return err
+		}
+	}
+	if err := f.GenerateInputs(); err != nil {
+		return err
+	}
+	return f.Save()
+}
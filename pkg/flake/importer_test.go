@@ -0,0 +1,132 @@
+package flake
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writes a legacy nix file into a temporary directory and imports it into a flake.nix next to it
+func importLegacy(t *testing.T, src string, opts Options) (*Import, *Flake) {
+	t.Helper()
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "default.nix")
+	if err := os.WriteFile(legacy, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := ImportLegacy(legacy, filepath.Join(dir, "flake.nix"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(filepath.Join(dir, "flake.nix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNixSyntax(f.String()); err != nil {
+		t.Errorf("imported flake: %v", err)
+	}
+	return l, f
+}
+
+func TestImportLegacyShell(t *testing.T) {
+	l, f := importLegacy(t, `{ pkgs ? import <nixpkgs> { } }:
+
+pkgs.mkShell {
+  name = "dev";
+  buildInputs = with pkgs; [
+    gopls
+    go
+    nodejs_20
+    nodejs
+    (callPackage ./tool.nix { })
+  ];
+  nativeBuildInputs = [ pkgs.pkg-config ];
+  GOFLAGS = "-mod=vendor";
+  shellHook = ''
+    echo hello
+  '';
+}
+`, Options{NixpkgsURL: "github:NixOS/nixpkgs/nixos-24.05"})
+
+	want := []string{"gopls", "go", "nodejs_20", "nodejs", "pkg-config"}
+	if !reflect.DeepEqual(l.Packages, want) {
+		t.Errorf("imported packages are %q, want %q", l.Packages, want)
+	}
+	// the `with pkgs;` style is kept
+	packages, err := f.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range packages {
+		names = append(names, p.Name)
+	}
+	if !reflect.DeepEqual(names, want) || !strings.Contains(f.String(), "packages = with pkgs; [") {
+		t.Errorf("flake packages are %q, want %q in a `with pkgs;` list:\n%s", names, want, f.String())
+	}
+	if len(l.Warnings) != 1 || !strings.Contains(l.Warnings[0], "callPackage") {
+		t.Errorf("warnings are %q, want one about callPackage", l.Warnings)
+	}
+	for _, want := range []string{`GOFLAGS = "-mod=vendor";`, `"github:NixOS/nixpkgs/nixos-24.05"`, "echo hello"} {
+		if !strings.Contains(f.String(), want) {
+			t.Errorf("the flake lacks %q:\n%s", want, f.String())
+		}
+	}
+	// a shell.nix has no derivation to carry over
+	if _, err := os.Stat(f.resolve(packageYAMLPath)); !os.IsNotExist(err) {
+		t.Errorf("importing a mkShell created %s", packageYAMLPath)
+	}
+}
+
+func TestImportLegacyDerivation(t *testing.T) {
+	src := `let
+  pkgs = import (fetchTarball "https://example.com/nixpkgs.tar.gz") { };
+in
+pkgs.stdenv.mkDerivation {
+  pname = "hello";
+  version = "1.0";
+  src = ./.;
+  buildInputs = [ pkgs.openssl ];
+  buildPhase = "make";
+}
+`
+	l, f := importLegacy(t, src, Options{})
+	pkg, err := f.PackageYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (PackageYAML{Pname: "hello", Version: "1.0", Src: "./.", Packages: []string{"openssl"}}); !reflect.DeepEqual(pkg, want) {
+		t.Errorf("%s is %+v, want %+v", packageYAMLPath, pkg, want)
+	}
+	if !strings.Contains(f.String(), `pname = "hello";`) || !strings.Contains(f.String(), "pkgs.openssl") {
+		t.Errorf("the derivation was not applied:\n%s", f.String())
+	}
+	if len(l.Warnings) != 1 || !strings.Contains(l.Warnings[0], "pinned nixpkgs") {
+		t.Errorf("warnings are %q, want one about the pinned nixpkgs", l.Warnings)
+	}
+
+	// the config turning derivations off wins
+	l, f = importLegacy(t, src, Options{NoDerivation: true})
+	if _, err := os.Stat(f.resolve(packageYAMLPath)); !os.IsNotExist(err) {
+		t.Errorf("importing with derivations turned off created %s", packageYAMLPath)
+	}
+	if len(l.Warnings) != 2 || !strings.Contains(l.Warnings[1], "mkDerivation is not imported") {
+		t.Errorf("warnings are %q, want one about the dropped derivation", l.Warnings)
+	}
+}
+
+func TestImportLegacyWithoutShell(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "default.nix")
+	if err := os.WriteFile(legacy, []byte(`{ pkgs }: pkgs.hello`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportLegacy(legacy, filepath.Join(dir, "flake.nix"), Options{}); KindOf(err) != KindParse {
+		t.Errorf("importing a file without mkShell gave %v, want a parse error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "flake.nix")); !os.IsNotExist(err) {
+		t.Error("a failed import wrote flake.nix")
+	}
+}
@@ -0,0 +1,70 @@
+package flake
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Input is an input declared in flake.nix and its locked revision
+type Input struct {
+	Name    string     `json:"name"`
+	URL     string     `json:"url,omitempty"`
+	Follows string     `json:"follows,omitempty"`
+	Locked  *LockedRef `json:"locked,omitempty"`
+}
+
+// Inputs returns the inputs of the flake in the order they are declared, locked by the flake.lock next to it
+func (f *Flake) Inputs() ([]Input, error) {
+	filePath := f.Path
+	body, ok := blockBody(f.content, "inputs = {")
+	if !ok {
+		return nil, nil
+	}
+	attrs, _, err := parseNixAttrs(strings.Join(body, "\n"))
+	if err != nil {
+		return nil, newError(KindParse, "could not parse the inputs of %s: %w", filePath, err)
+	}
+
+	var inputs []Input
+	index := map[string]int{}
+	set := func(name, key, value string) {
+		i, ok := index[name]
+		if !ok {
+			i = len(inputs)
+			index[name] = i
+			inputs = append(inputs, Input{Name: name})
+		}
+		s, _ := parseNixString(value)
+		switch key {
+		case "url":
+			inputs[i].URL = s
+		case "follows":
+			inputs[i].Follows = s
+		}
+	}
+	for _, a := range attrs {
+		name, key, _ := strings.Cut(a.Name, ".")
+		if key == "" && strings.HasPrefix(a.Value, "{") {
+			// nixpkgs = { url = "..."; };
+			nested, _, err := parseNixAttrs(strings.TrimSuffix(strings.TrimPrefix(a.Value, "{"), "}"))
+			if err != nil {
+				return nil, newError(KindParse, "could not parse input %s: %w", name, err)
+			}
+			for _, n := range nested {
+				set(name, n.Name, n.Value)
+			}
+			continue
+		}
+		set(name, key, a.Value)
+	}
+
+	lock, err := ReadLock(filepath.Join(filepath.Dir(filePath), "flake.lock"))
+	if err != nil {
+		return nil, err
+	}
+	refs := lock.RootInputs()
+	for i := range inputs {
+		inputs[i].Locked = refs[inputs[i].Name]
+	}
+	return inputs, nil
+}
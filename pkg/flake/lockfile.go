@@ -0,0 +1,116 @@
+package flake
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Lock is the structure of flake.lock
+type Lock struct {
+	Nodes   map[string]lockNode `json:"nodes"`
+	Root    string              `json:"root"`
+	Version int                 `json:"version"`
+}
+
+type lockNode struct {
+	// node name, or a follows path
+	Inputs map[string]json.RawMessage `json:"inputs"`
+	Locked *LockedRef                 `json:"locked"`
+}
+
+// LockedRef is the revision an input is locked to
+type LockedRef struct {
+	Type         string `json:"type"`
+	Owner        string `json:"owner"`
+	Repo         string `json:"repo"`
+	URL          string `json:"url"`
+	Rev          string `json:"rev"`
+	NarHash      string `json:"narHash"`
+	LastModified int64  `json:"lastModified"`
+}
+
+// LockChange is a root input whose locked revision changed
+type LockChange struct {
+	Input string     `json:"input"`
+	Old   *LockedRef `json:"old"`
+	New   *LockedRef `json:"new"`
+}
+
+// ReadLock reads flake.lock at path, a missing file is an empty lock
+func ReadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Lock{}, nil
+	} else if err != nil {
+		return nil, newError(KindIO, "could not read %s: %w", path, err)
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, newError(KindParse, "could not parse %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// RootInputs returns the locked ref of each direct input
+func (l *Lock) RootInputs() map[string]*LockedRef {
+	refs := map[string]*LockedRef{}
+	root, ok := l.Nodes[l.Root]
+	if !ok {
+		return refs
+	}
+	for name, raw := range root.Inputs {
+		var nodeName string
+		if err := json.Unmarshal(raw, &nodeName); err != nil {
+			// follows another input, not locked on its own
+			continue
+		}
+		if node, ok := l.Nodes[nodeName]; ok && node.Locked != nil {
+			refs[name] = node.Locked
+		}
+	}
+	return refs
+}
+
+// DiffLocks compares the direct inputs of two lock files
+func DiffLocks(old, new *Lock) []LockChange {
+	oldRefs := old.RootInputs()
+	newRefs := new.RootInputs()
+
+	var names []string
+	for name := range newRefs {
+		names = append(names, name)
+	}
+	for name := range oldRefs {
+		if _, ok := newRefs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []LockChange
+	for _, name := range names {
+		o, n := oldRefs[name], newRefs[name]
+		if o != nil && n != nil && o.Rev == n.Rev && o.NarHash == n.NarHash {
+			continue
+		}
+		changes = append(changes, LockChange{Input: name, Old: o, New: n})
+	}
+	return changes
+}
+
+// ShortRev returns the short revision of a locked ref
+func (r *LockedRef) ShortRev() string {
+	if r == nil {
+		return "(none)"
+	}
+	rev := r.Rev
+	if rev == "" {
+		rev = strings.TrimPrefix(r.NarHash, "sha256-")
+	}
+	if len(rev) > 7 {
+		rev = rev[:7]
+	}
+	return rev
+}
@@ -0,0 +1,83 @@
+package flake
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// flake.lock before and after nix flake update: nixpkgs moved, gone was dropped, added is new and
+// flake-utils and the input following nixpkgs stayed
+const (
+	oldLock = `{
+  "nodes": {
+    "flake-utils": { "locked": { "type": "github", "rev": "bbbbbbbbbb" } },
+    "gone": { "locked": { "type": "github", "rev": "cccccccccc" } },
+    "nixpkgs": { "locked": { "type": "github", "rev": "aaaaaaaaaa", "lastModified": 1700000000 } },
+    "root": { "inputs": { "flake-utils": "flake-utils", "gone": "gone", "nixpkgs": "nixpkgs", "follows": [ "nixpkgs" ] } }
+  },
+  "root": "root",
+  "version": 7
+}`
+	newLock = `{
+  "nodes": {
+    "added": { "locked": { "type": "tarball", "narHash": "sha256-eeeeeeeeee" } },
+    "flake-utils": { "locked": { "type": "github", "rev": "bbbbbbbbbb" } },
+    "nixpkgs_2": { "locked": { "type": "github", "rev": "dddddddddd" } },
+    "root": { "inputs": { "added": "added", "flake-utils": "flake-utils", "nixpkgs": "nixpkgs_2", "follows": [ "nixpkgs" ] } }
+  },
+  "root": "root",
+  "version": 7
+}`
+)
+
+func TestDiffLocks(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.lock"), filepath.Join(dir, "new.lock")
+	if err := os.WriteFile(oldPath, []byte(oldLock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newPath, []byte(newLock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := ReadLock(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := ReadLock(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range DiffLocks(old, updated) {
+		got = append(got, c.Input+" "+c.Old.ShortRev()+" "+c.New.ShortRev())
+	}
+	want := "added (none) eeeeeee, gone ccccccc (none), nixpkgs aaaaaaa ddddddd"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("changes are %q, want %s", got, want)
+	}
+	if changes := DiffLocks(old, old); len(changes) != 0 {
+		t.Errorf("a lock changed against itself: %+v", changes)
+	}
+
+	// a missing flake.lock is empty, every input is new
+	missing, err := ReadLock(filepath.Join(dir, "flake.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := DiffLocks(missing, updated); len(changes) != 3 {
+		t.Errorf("changes from a missing lock are %+v, want 3", changes)
+	}
+
+	if err := os.WriteFile(oldPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadLock(oldPath); KindOf(err) != KindParse {
+		t.Errorf("reading a broken lock gave %v, want a parse error", err)
+	}
+	if _, err := ReadLock(dir); KindOf(err) != KindIO {
+		t.Errorf("reading a directory gave %v, want an io error", err)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package flake
 
 import (
 	"fmt"
@@ -7,28 +7,25 @@ import (
 )
 
 // ensure defaultPackage exists
-func ensureDerivation(filePath string) error {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %w", filePath, err)
-	}
-	flake := string(content)
+func (f *Flake) ensureDerivation() error {
+	flake := f.content
 
 	// check for derivation
-	if strings.Contains(flake, "defaultPackage =") ||
+	attr := derivationAttr(flake)
+	if strings.Contains(flake, attr+" =") ||
 		strings.Contains(flake, "pkgs.stdenv.mkDerivation") ||
 		strings.Contains(flake, "mkDerivation {") {
 		return nil
 	}
 
-	pkgsList, _ := getPackagesFromPackageYML()
+	pkgsList, _ := f.getPackagesFromPackageYML()
 
 	// detect indentation
 	indent := ""
 	lines := strings.Split(flake, "\n")
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "devShells ") || strings.HasPrefix(trimmed, "devShells=") {
+		if strings.HasPrefix(trimmed, "devShells ") || strings.HasPrefix(trimmed, "devShells=") || strings.HasPrefix(trimmed, "devShells.") {
 			indent = getLineIndentation(line)
 			break
 		}
@@ -57,7 +54,8 @@ func ensureDerivation(filePath string) error {
 
 	// build mkDerivation block
 	var block []string
-	block = append(block, indent+"defaultPackage = pkgs.stdenv.mkDerivation {")
+	block = append(block, "")
+	block = append(block, indent+attr+" = pkgs.stdenv.mkDerivation {")
 	block = append(block, indent+"  pname = \"default\";")
 	block = append(block, indent+"  version = \"0.1\";")
 	block = append(block, indent+"  src = ./.;")
@@ -77,7 +75,7 @@ func ensureDerivation(filePath string) error {
 	// find insert index
 	insertIdx, err := findInsertIndex(flake)
 	if err != nil {
-		return fmt.Errorf("could not find insertion point in %s: %w", filePath, err)
+		return fmt.Errorf("could not find insertion point in %s: %w", f.Path, err)
 	}
 
 	// insert block
@@ -87,9 +85,7 @@ func ensureDerivation(filePath string) error {
 	}
 	newFlake += strings.Join(block, "\n") + flake[insertIdx:]
 
-	if err := os.WriteFile(filePath, []byte(newFlake), 0644); err != nil {
-		return fmt.Errorf("could not write to %s: %w", filePath, err)
-	}
+	f.content = newFlake
 	return nil
 }
 
@@ -113,8 +109,11 @@ func findInsertIndex(flake string) (int, error) {
 					depth--
 					if started && depth == 0 {
 						j := i + 1
-						// skip whitespace
-						for j < len(flake) && (flake[j] == ' ' || flake[j] == '\n' || flake[j] == '\r' || flake[j] == ';' || flake[j] == '\t') {
+						// skip to the start of the next line
+						for j < len(flake) && (flake[j] == ' ' || flake[j] == '\r' || flake[j] == ';' || flake[j] == '\t') {
+							j++
+						}
+						if j < len(flake) && flake[j] == '\n' {
 							j++
 						}
 						return j, nil
@@ -130,11 +129,11 @@ func findInsertIndex(flake string) (int, error) {
 		inIdx = strings.Index(flake, "in{")
 	}
 	if inIdx == -1 {
-		return -1, fmt.Errorf("could not find 'devShells' or 'in {'")
+		return -1, newError(KindParse, "could not find 'devShells' or 'in {'")
 	}
 	braceRel := strings.Index(flake[inIdx:], "{")
 	if braceRel == -1 {
-		return -1, fmt.Errorf("malformed 'in' block")
+		return -1, newError(KindParse, "malformed 'in' block")
 	}
 	openIdx := inIdx + braceRel
 	nlRel := strings.Index(flake[openIdx:], "\n")
@@ -143,3 +142,45 @@ func findInsertIndex(flake string) (int, error) {
 	}
 	return openIdx + 1, nil
 }
+
+// EnableDerivation adds the derivation and its .flk/derivation files to a shell only flake
+func (f *Flake) EnableDerivation() error {
+	if drv, err := f.Derivation(); err != nil {
+		return err
+	} else if drv != nil {
+		return newError(KindAlreadyExists, "%s already has a derivation", f.Path)
+	}
+	pkgs, err := f.getPackages()
+	if err != nil {
+		return err
+	}
+	return f.generateDerivation(pkgs)
+}
+
+// DisableDerivation removes the derivation block and the .flk/derivation folder
+func (f *Flake) DisableDerivation() error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	if _, enabled, err := f.readImageConfig(); err != nil {
+		return err
+	} else if enabled {
+		return newError(KindConflict, "the image is built from the derivation, remove %s first", imageConfigPath)
+	}
+
+	lines := strings.Split(f.content, "\n")
+	start, end := findAttrBlock(lines, derivationAttr(f.content)+" = pkgs.stdenv.mkDerivation {")
+	if start == -1 {
+		return newError(KindNotFound, "could not find the flk derivation in %s", f.Path)
+	}
+	// drop the blank line before it too
+	if start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+		start--
+	}
+	f.content = strings.Join(append(lines[:start], lines[end+1:]...), "\n")
+
+	if err := os.RemoveAll(f.resolve(".flk/derivation")); err != nil {
+		return fmt.Errorf("could not remove .flk/derivation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const modulesDir = ".flk/modules"
+
+// module files and the top-level output each one is exported as
+var moduleOutputs = []struct {
+	File   string
+	Output string
+}{
+	{"nixos.nix", "nixosModules.default"},
+	{"home-manager.nix", "homeManagerModules.default"},
+}
+
+// modules take the flake's self so they can default to the flk derivation
+var nixosModuleTemplate = template.Must(template.New("nixos").Parse(`self:
+{ config, lib, pkgs, ... }:
+
+let
+  cfg = config.services.{{.Attr}};
+{{- if .Settings}}
+  settingsFormat = pkgs.formats.json { };
+{{- end}}
+in {
+  options.services.{{.Attr}} = {
+    enable = lib.mkEnableOption "{{.Name}}";
+
+    package = lib.mkOption {
+      type = lib.types.package;
+      default = self.packages.${pkgs.stdenv.hostPlatform.system}.default or self.defaultPackage.${pkgs.stdenv.hostPlatform.system};
+      description = "The {{.Name}} package to use.";
+    };
+{{- if .Settings}}
+
+    settings = lib.mkOption {
+      type = settingsFormat.type;
+      default = { };
+      description = "Configuration written to {{.Name}}.json and passed with --config.";
+    };
+{{- end}}
+  };
+
+  config = lib.mkIf cfg.enable {
+{{- if .Service}}
+    systemd.services.{{.Attr}} = {
+      description = "{{.Name}}";
+      wantedBy = [ "multi-user.target" ];
+      after = [ "network.target" ];
+      serviceConfig = {
+        ExecStart = "${cfg.package}/bin/{{.Name}}{{if .Settings}} --config ${settingsFormat.generate "{{.Name}}.json" cfg.settings}{{end}}";
+        DynamicUser = true;
+        Restart = "on-failure";
+      };
+    };
+{{- else}}
+    environment.systemPackages = [ cfg.package ];
+{{- end}}
+  };
+}
+`))
+
+var homeManagerModuleTemplate = template.Must(template.New("home-manager").Parse(`self:
+{ config, lib, pkgs, ... }:
+
+let
+  cfg = config.services.{{.Attr}};
+{{- if .Settings}}
+  settingsFormat = pkgs.formats.json { };
+{{- end}}
+in {
+  options.services.{{.Attr}} = {
+    enable = lib.mkEnableOption "{{.Name}}";
+
+    package = lib.mkOption {
+      type = lib.types.package;
+      default = self.packages.${pkgs.stdenv.hostPlatform.system}.default or self.defaultPackage.${pkgs.stdenv.hostPlatform.system};
+      description = "The {{.Name}} package to use.";
+    };
+{{- if .Settings}}
+
+    settings = lib.mkOption {
+      type = settingsFormat.type;
+      default = { };
+      description = "Configuration written to {{.Name}}.json and passed with --config.";
+    };
+{{- end}}
+  };
+
+  config = lib.mkIf cfg.enable {
+    home.packages = [ cfg.package ];
+{{- if .Service}}
+
+    systemd.user.services.{{.Attr}} = {
+      Unit.Description = "{{.Name}}";
+      Install.WantedBy = [ "default.target" ];
+      Service = {
+        ExecStart = "${cfg.package}/bin/{{.Name}}{{if .Settings}} --config ${settingsFormat.generate "{{.Name}}.json" cfg.settings}{{end}}";
+        Restart = "on-failure";
+      };
+    };
+{{- end}}
+  };
+}
+`))
+
+// InitModules creates the module skeletons in .flk/modules and wires them into the outputs. With service the
+// derivation runs as a systemd service, and with settings the service gets a settings option passed as --config.
+func (f *Flake) InitModules(service, settings, homeManager bool) ([]string, error) {
+	if err := f.requireFlake(); err != nil {
+		return nil, err
+	}
+	if settings && !service {
+		return nil, newError(KindUsage, "settings are passed to the service, add --service")
+	}
+	pkg, err := f.readPackageYAML()
+	if err != nil {
+		return nil, err
+	}
+	// the option, the service and its binary are named after the derivation
+	if pkg.Pname == "" || pkg.Pname == "default" {
+		return nil, newError(KindConflict, "the modules are named after the derivation, set its pname in %s first", packageYAMLPath)
+	}
+	data := struct {
+		Name     string
+		Attr     string
+		Service  bool
+		Settings bool
+	}{pkg.Pname, nixAttrName(pkg.Pname), service, settings}
+
+	modules := map[string]*template.Template{"nixos.nix": nixosModuleTemplate}
+	if homeManager {
+		modules["home-manager.nix"] = homeManagerModuleTemplate
+	}
+
+	if err := os.MkdirAll(f.resolve(modulesDir), 0755); err != nil {
+		return nil, fmt.Errorf("could not create %s folder: %w", modulesDir, err)
+	}
+
+	var created []string
+	for _, m := range moduleOutputs {
+		tmpl, ok := modules[m.File]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(modulesDir, m.File)
+		if _, err := os.Stat(f.resolve(path)); err == nil {
+			return nil, newError(KindAlreadyExists, "%s already exists", path)
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("could not render %s: %w", path, err)
+		}
+		if err := os.WriteFile(f.resolve(path), []byte(b.String()), 0644); err != nil {
+			return nil, fmt.Errorf("could not write %s: %w", path, err)
+		}
+		created = append(created, path)
+	}
+
+	return created, f.applyModules()
+}
+
+// exports each module in .flk/modules, removing outputs whose module is gone
+func (f *Flake) applyModules() error {
+	flake := f.content
+
+	self := "self"
+	if flakeStyle(flake) == StyleFlakeParts {
+		self = "inputs.self"
+	}
+
+	for _, m := range moduleOutputs {
+		path := filepath.Join(modulesDir, m.File)
+		if _, err := os.Stat(f.resolve(path)); os.IsNotExist(err) {
+			flake = removeTopLevelOutput(flake, m.Output)
+			continue
+		}
+		var err error
+		flake, err = setTopLevelOutput(flake, m.Output, fmt.Sprintf("import ./%s %s", path, self))
+		if err != nil {
+			return fmt.Errorf("could not export %s in %s: %w", path, f.Path, err)
+		}
+	}
+
+	f.content = flake
+	return nil
+}
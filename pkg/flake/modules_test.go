@@ -0,0 +1,48 @@
+package flake
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInitModules(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	if _, err := f.InitModules(true, false, false); KindOf(err) != KindConflict {
+		t.Errorf("modules for the placeholder pname gave %v, want a conflict", err)
+	}
+	if _, err := f.InitModules(false, true, false); KindOf(err) != KindUsage {
+		t.Errorf("settings without a service gave %v, want a usage error", err)
+	}
+
+	pkg, err := f.PackageYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.Pname = "my.tool"
+	if err := f.writePackageYAML(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.InitModules(true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"nixos.nix", "home-manager.nix"} {
+		data, err := os.ReadFile(f.resolve(modulesDir + "/" + file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		module := string(data)
+		if !strings.Contains(module, `options.services."my.tool" = {`) || !strings.Contains(module, `ExecStart = "${cfg.package}/bin/my.tool";`) {
+			t.Errorf("%s does not quote the service name or passes settings:\n%s", file, module)
+		}
+		if strings.Contains(module, "settings") {
+			t.Errorf("%s has settings without --settings:\n%s", file, module)
+		}
+		if err := checkNixSyntax(module); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+	if !strings.Contains(f.String(), "nixosModules.default = import ./.flk/modules/nixos.nix self;") {
+		t.Errorf("the nixos module is not exported:\n%s", f.String())
+	}
+}
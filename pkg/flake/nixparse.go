@@ -0,0 +1,387 @@
+package flake
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// an attribute of a nix attrset, Value is the raw expression
+type nixAttr struct {
+	Name  string
+	Value string
+}
+
+var (
+	nixAttrNameRegex    = regexp.MustCompile(`^[a-zA-Z_"][a-zA-Z0-9_.'"-]*$`)
+	withOnlyRegex       = regexp.MustCompile(`^(\s*with\s+[a-zA-Z0-9_.]+\s*;)*\s*with\s+[a-zA-Z0-9_.]+\s*$`)
+	withPrefixRegex     = regexp.MustCompile(`^(with\s+[a-zA-Z0-9_.]+\s*;\s*)+`)
+	lambdaAtRegex       = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_'-]*)\s*@\s*`)
+	lambdaAtSuffixRegex = regexp.MustCompile(`^@\s*([a-zA-Z_][a-zA-Z0-9_'-]*)\s*`)
+)
+
+// skips whitespace and comments starting at i
+func skipNixSpace(s string, i int) int {
+	for i < len(s) {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r':
+			i++
+		case s[i] == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				return len(s)
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// returns the index just past the string starting at i
+func skipNixString(s string, i int) (int, error) {
+	if strings.HasPrefix(s[i:], "''") {
+		for j := i + 2; j < len(s)-1; j++ {
+			if s[j] == '\'' && s[j+1] == '\'' {
+				// '' escapes: ''' ''$ ''\
+				if j+2 < len(s) && (s[j+2] == '\'' || s[j+2] == '$' || s[j+2] == '\\') {
+					j += 2
+					continue
+				}
+				return j + 2, nil
+			}
+		}
+		return -1, fmt.Errorf("unterminated '' string")
+	}
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, nil
+		}
+	}
+	return -1, fmt.Errorf("unterminated string")
+}
+
+// returns the index of the first stop byte outside strings, comments and brackets
+func scanNixExpr(s string, i int, stop string) (int, error) {
+	depth := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '"' || strings.HasPrefix(s[i:], "''"):
+			end, err := skipNixString(s, i)
+			if err != nil {
+				return -1, err
+			}
+			i = end
+			continue
+		case c == '#' || strings.HasPrefix(s[i:], "/*"):
+			i = skipNixSpace(s, i)
+			continue
+		case depth == 0 && strings.IndexByte(stop, c) != -1:
+			return i, nil
+		case (i == 0 || !isNixIdentByte(s[i-1])) && hasNixKeyword(s, i, "let"):
+			// bindings of a let block end with ; too
+			depth++
+			i += 3
+			continue
+		case depth > 0 && (i == 0 || !isNixIdentByte(s[i-1])) && hasNixKeyword(s, i, "in"):
+			depth--
+			i += 2
+			continue
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case c == '}' || c == ']' || c == ')':
+			depth--
+			if depth < 0 {
+				return -1, fmt.Errorf("unbalanced %q", c)
+			}
+		}
+		i++
+	}
+	return -1, fmt.Errorf("expected one of %q", stop)
+}
+
+// parses the body of an attrset into its attributes, inherits are returned as warnings
+func parseNixAttrs(body string) ([]nixAttr, []string, error) {
+	var attrs []nixAttr
+	var warnings []string
+
+	i := 0
+	for {
+		i = skipNixSpace(body, i)
+		if i >= len(body) {
+			return attrs, warnings, nil
+		}
+
+		eq, err := scanNixExpr(body, i, "=;")
+		if err != nil {
+			return nil, nil, err
+		}
+		name := strings.TrimSpace(body[i:eq])
+		if body[eq] == ';' {
+			warnings = append(warnings, fmt.Sprintf("skipped `%s;`", name))
+			i = eq + 1
+			continue
+		}
+		if !nixAttrNameRegex.MatchString(name) {
+			return nil, nil, fmt.Errorf("unexpected %q", name)
+		}
+
+		end, err := scanNixExpr(body, eq+1, ";")
+		if err != nil {
+			return nil, nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		// `with pkgs; [ ... ]` continues past its own semicolon
+		for withOnlyRegex.MatchString(body[eq+1 : end]) {
+			end, err = scanNixExpr(body, end+1, ";")
+			if err != nil {
+				return nil, nil, fmt.Errorf("attribute %s: %w", name, err)
+			}
+		}
+		attrs = append(attrs, nixAttr{Name: strings.Trim(name, `"`), Value: strings.TrimSpace(body[eq+1 : end])})
+		i = end + 1
+	}
+}
+
+// splits a list expression into its elements
+func parseNixList(expr string) ([]string, bool) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "[") || !strings.HasSuffix(expr, "]") {
+		return nil, false
+	}
+	body := expr[1 : len(expr)-1]
+
+	var items []string
+	i := 0
+	for {
+		i = skipNixSpace(body, i)
+		if i >= len(body) {
+			return items, true
+		}
+		start := i
+		switch {
+		case body[i] == '"' || strings.HasPrefix(body[i:], "''"):
+			end, err := skipNixString(body, i)
+			if err != nil {
+				return nil, false
+			}
+			i = end
+		case body[i] == '(' || body[i] == '[' || body[i] == '{':
+			end, err := scanNixExpr(body, i+1, ")]}")
+			if err != nil {
+				return nil, false
+			}
+			i = end + 1
+		default:
+			for i < len(body) && !strings.ContainsRune(" \t\r\n#", rune(body[i])) {
+				i++
+			}
+		}
+		items = append(items, body[start:i])
+	}
+}
+
+// returns the content of a string expression, stripping indented string indentation like nix does
+func parseNixString(expr string) (string, bool) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, `"`) && strings.HasSuffix(expr, `"`) && len(expr) >= 2 {
+		r := strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\$`, "$", `\\`, `\`)
+		return r.Replace(expr[1 : len(expr)-1]), true
+	}
+	body, ok := indentedStringBody(expr)
+	if !ok {
+		return "", false
+	}
+	return strings.NewReplacer("'''", "''", "''$", "$", "''\\", "\\").Replace(body), true
+}
+
+// returns a string expression as the body of an indented string, the way .flk scripts are stored
+func parseNixScript(expr string) (string, bool) {
+	if body, ok := indentedStringBody(expr); ok {
+		return body, true
+	}
+	s, ok := parseNixString(expr)
+	if !ok {
+		return "", false
+	}
+	return escapeForIndentedString(s), true
+}
+
+// returns the dedented, still escaped body of an indented string
+func indentedStringBody(expr string) (string, bool) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "''") || !strings.HasSuffix(expr, "''") || len(expr) < 4 {
+		return "", false
+	}
+
+	lines := strings.Split(expr[2:len(expr)-2], "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	common := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if n := len(getLineIndentation(l)); common == -1 || n < common {
+			common = n
+		}
+	}
+	for i, l := range lines {
+		if len(l) >= common && common > 0 {
+			lines[i] = l[common:]
+		} else if strings.TrimSpace(l) == "" {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// finds the attrset passed to the first call of fn, e.g. mkShell
+func findCallAttrs(src, fn string) (string, bool) {
+	idx := strings.Index(src, fn)
+	for idx != -1 {
+		i := skipNixSpace(src, idx+len(fn))
+		if i < len(src) && src[i] == '{' {
+			end, err := scanNixExpr(src, i+1, "}")
+			if err != nil {
+				return "", false
+			}
+			return src[i+1 : end], true
+		}
+		next := strings.Index(src[idx+len(fn):], fn)
+		if next == -1 {
+			break
+		}
+		idx += len(fn) + next
+	}
+	return "", false
+}
+
+// a binding of an attrset or let block, the offsets index src
+type nixBinding struct {
+	src   string
+	Name  string // as written, empty for inherit
+	start int    // just past the previous binding, so leading comments belong to this one
+	name  int
+	value int // just past the =
+	end   int // just past the ;
+}
+
+// the binding with the comments and blank lines before it
+func (b nixBinding) Text() string {
+	return b.src[b.start:b.end]
+}
+
+// the value expression without the ;
+func (b nixBinding) Value() string {
+	return strings.TrimSpace(b.src[b.value : b.end-1])
+}
+
+// the binding text with its name replaced
+func (b nixBinding) Renamed(name string) string {
+	return b.src[b.start:b.name] + name + b.src[b.name+len(b.Name):b.end]
+}
+
+// reports whether the keyword kw starts at i
+func hasNixKeyword(s string, i int, kw string) bool {
+	if !strings.HasPrefix(s[i:], kw) {
+		return false
+	}
+	j := i + len(kw)
+	return j == len(s) || !isNixIdentByte(s[j])
+}
+
+// reports whether c can be part of an identifier or attribute path
+func isNixIdentByte(c byte) bool {
+	return c == '_' || c == '-' || c == '\'' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// scans the bindings of s between i and end, a let block stops at its `in` and returns the index past it
+func scanNixBindings(s string, i, end int, let bool) ([]nixBinding, int, error) {
+	var bindings []nixBinding
+	for {
+		start := i
+		i = skipNixSpace(s[:end], i)
+		if i >= end {
+			if let {
+				return nil, -1, fmt.Errorf("expected in")
+			}
+			return bindings, end, nil
+		}
+		if let && hasNixKeyword(s, i, "in") {
+			return bindings, i + 2, nil
+		}
+
+		eq, err := scanNixExpr(s[:end], i, "=;")
+		if err != nil {
+			return nil, -1, err
+		}
+		b := nixBinding{src: s, start: start, name: i, value: eq + 1}
+		if s[eq] == ';' {
+			b.end = eq + 1
+			bindings = append(bindings, b)
+			i = b.end
+			continue
+		}
+		b.Name = strings.TrimSpace(s[i:eq])
+
+		semi, err := scanNixExpr(s[:end], eq+1, ";")
+		if err != nil {
+			return nil, -1, fmt.Errorf("attribute %s: %w", b.Name, err)
+		}
+		for withOnlyRegex.MatchString(s[eq+1 : semi]) {
+			semi, err = scanNixExpr(s[:end], semi+1, ";")
+			if err != nil {
+				return nil, -1, fmt.Errorf("attribute %s: %w", b.Name, err)
+			}
+		}
+		b.end = semi + 1
+		bindings = append(bindings, b)
+		i = b.end
+	}
+}
+
+// parses the `name@{ args }:` head of a function starting at i, returns the index of its body
+func parseNixLambdaHead(s string, i int) (string, []string, int, error) {
+	i = skipNixSpace(s, i)
+	at := ""
+	if m := lambdaAtRegex.FindStringSubmatch(s[i:]); m != nil {
+		at = m[1]
+		i += len(m[0])
+	}
+	if i >= len(s) || s[i] != '{' {
+		return "", nil, -1, fmt.Errorf("expected a function with an argument set")
+	}
+	close, err := scanNixExpr(s, i+1, "}")
+	if err != nil {
+		return "", nil, -1, err
+	}
+	var args []string
+	for _, a := range strings.Split(s[i+1:close], ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			args = append(args, a)
+		}
+	}
+	i = skipNixSpace(s, close+1)
+	if m := lambdaAtSuffixRegex.FindStringSubmatch(s[i:]); m != nil {
+		at = m[1]
+		i = skipNixSpace(s, i+len(m[0]))
+	}
+	if i >= len(s) || s[i] != ':' {
+		return "", nil, -1, fmt.Errorf("expected :")
+	}
+	return at, args, i + 1, nil
+}
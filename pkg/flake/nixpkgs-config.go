@@ -0,0 +1,190 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const nixpkgsConfigPath = ".flk/nixpkgs.yml"
+
+// structure of .flk/nixpkgs.yml, unknown keys are passed to config as is
+type NixpkgsYAML struct {
+	AllowUnfree               bool                   `yaml:"allowUnfree,omitempty"`
+	AllowUnfreePredicate      []string               `yaml:"allowUnfreePredicate,omitempty"`
+	PermittedInsecurePackages []string               `yaml:"permittedInsecurePackages,omitempty"`
+	Extra                     map[string]interface{} `yaml:",inline"`
+}
+
+var nixIdentRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'-]*$`)
+
+// reads .flk/nixpkgs.yml, a missing file is an empty config
+func (f *Flake) readNixpkgsConfig() (NixpkgsYAML, error) {
+	var cfg NixpkgsYAML
+	data, err := os.ReadFile(f.resolve(nixpkgsConfigPath))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, fmt.Errorf("could not read %s: %w", nixpkgsConfigPath, err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, newError(KindParse, "could not unmarshal %s: %w", nixpkgsConfigPath, err)
+	}
+	return cfg, nil
+}
+
+func (f *Flake) writeNixpkgsConfig(cfg NixpkgsYAML) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s: %w", nixpkgsConfigPath, err)
+	}
+	if err := os.MkdirAll(f.resolve(".flk"), 0755); err != nil {
+		return fmt.Errorf("could not create .flk folder: %w", err)
+	}
+	if err := os.WriteFile(f.resolve(nixpkgsConfigPath), data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", nixpkgsConfigPath, err)
+	}
+	return nil
+}
+
+// renders the config attrset, empty if nothing is set
+func renderNixpkgsConfig(cfg NixpkgsYAML, lib string) (string, error) {
+	var attrs []string
+	if cfg.AllowUnfree {
+		attrs = append(attrs, "allowUnfree = true;")
+	}
+	if len(cfg.AllowUnfreePredicate) > 0 {
+		attrs = append(attrs, fmt.Sprintf("allowUnfreePredicate = pkg: builtins.elem (%s.getName pkg) %s;", lib, nixStringList(cfg.AllowUnfreePredicate)))
+	}
+	if len(cfg.PermittedInsecurePackages) > 0 {
+		attrs = append(attrs, "permittedInsecurePackages = "+nixStringList(cfg.PermittedInsecurePackages)+";")
+	}
+
+	keys := make([]string, 0, len(cfg.Extra))
+	for k := range cfg.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, err := toNix(cfg.Extra[k])
+		if err != nil {
+			return "", fmt.Errorf("config key %s: %w", k, err)
+		}
+		attrs = append(attrs, nixAttrName(k)+" = "+value+";")
+	}
+
+	if len(attrs) == 0 {
+		return "", nil
+	}
+	return "{ " + strings.Join(attrs, " ") + " }", nil
+}
+
+// renders a yaml value as a nix expression
+func toNix(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case string:
+		return nixString(val), nil
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			s, err := toNix(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return "[ " + strings.Join(items, " ") + " ]", nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var attrs []string
+		for _, k := range keys {
+			s, err := toNix(val[k])
+			if err != nil {
+				return "", err
+			}
+			attrs = append(attrs, nixAttrName(k)+" = "+s+";")
+		}
+		return "{ " + strings.Join(attrs, " ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// quotes s as a nix string
+func nixString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func nixStringList(list []string) string {
+	items := make([]string, len(list))
+	for i, s := range list {
+		items[i] = nixString(s)
+	}
+	return "[ " + strings.Join(items, " ") + " ]"
+}
+
+// quotes an attribute name when it is not a plain identifier
+func nixAttrName(name string) string {
+	if nixIdentRegex.MatchString(name) {
+		return name
+	}
+	return nixString(name)
+}
+
+// AllowUnfree allows unfree packages, all of them when pkgs is empty
+func (f *Flake) AllowUnfree(pkgs []string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	cfg, err := f.readNixpkgsConfig()
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		cfg.AllowUnfree = true
+	}
+	for _, p := range pkgs {
+		if !containsString(cfg.AllowUnfreePredicate, p) {
+			cfg.AllowUnfreePredicate = append(cfg.AllowUnfreePredicate, p)
+		}
+	}
+	if err := f.writeNixpkgsConfig(cfg); err != nil {
+		return err
+	}
+	return f.applyPkgsImport()
+}
+
+// PermitInsecure permits an insecure package such as openssl-1.1.1w
+func (f *Flake) PermitInsecure(pkg string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	cfg, err := f.readNixpkgsConfig()
+	if err != nil {
+		return err
+	}
+	if !containsString(cfg.PermittedInsecurePackages, pkg) {
+		cfg.PermittedInsecurePackages = append(cfg.PermittedInsecurePackages, pkg)
+	}
+	if err := f.writeNixpkgsConfig(cfg); err != nil {
+		return err
+	}
+	return f.applyPkgsImport()
+}
@@ -0,0 +1,50 @@
+package flake
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNixpkgsConfig(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	// keys flk has no command for are passed to config as they are
+	extra := "cudaSupport: true\ncudaCapabilities: [\"8.6\"]\nrocm.version: 6\n"
+	if err := os.WriteFile(f.resolve(nixpkgsConfigPath), []byte(extra), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AllowUnfree([]string{"steam"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AllowUnfree([]string{"steam"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.PermitInsecure("openssl-1.1.1w"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `config = { allowUnfreePredicate = pkg: builtins.elem (nixpkgs.lib.getName pkg) [ "steam" ]; permittedInsecurePackages = [ "openssl-1.1.1w" ]; cudaCapabilities = [ "8.6" ]; cudaSupport = true; "rocm.version" = 6; };`
+	if !strings.Contains(f.String(), want) {
+		t.Errorf("the flake lacks %q:\n%s", want, f.String())
+	}
+	if err := checkNixSyntax(f.String()); err != nil {
+		t.Error(err)
+	}
+
+	if err := f.AllowUnfree(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(f.String(), "config = { allowUnfree = true; allowUnfreePredicate") {
+		t.Errorf("allowing all unfree packages is not in the config:\n%s", f.String())
+	}
+
+	if err := os.WriteFile(f.resolve(nixpkgsConfigPath), []byte("x: { y: [ { z: 1 } ] }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.PermitInsecure("python-2.7.18"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(f.String(), `x = { y = [ { z = 1; } ]; };`) {
+		t.Errorf("nested values are not rendered:\n%s", f.String())
+	}
+}
@@ -0,0 +1,228 @@
+package flake
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pkgs bindings managed by flk for each style
+var (
+	pkgsImportRegex      = regexp.MustCompile(`^(\s*)pkgs = import nixpkgs \{.*\};\s*$`)
+	partsPkgsImportRegex = regexp.MustCompile(`^(\s*)_module\.args\.pkgs = import inputs\.nixpkgs \{.*\};\s*$`)
+)
+
+// nixpkgs lib as seen from the outputs of each style
+func nixpkgsLib(flake string) string {
+	if flakeStyle(flake) == StyleFlakeParts {
+		return "inputs.nixpkgs.lib"
+	}
+	return "nixpkgs.lib"
+}
+
+// wires overlays and .flk/nixpkgs.yml into the pkgs import and exports overlays.default
+func (f *Flake) applyPkgsImport() error {
+	names, err := f.Overlays()
+	if err != nil {
+		return err
+	}
+	cfg, err := f.readNixpkgsConfig()
+	if err != nil {
+		return err
+	}
+
+	filePath := f.Path
+	flake := f.content
+	lib := nixpkgsLib(flake)
+
+	var args []string
+	if len(names) > 0 {
+		args = append(args, "overlays = [ "+strings.Join(overlayImports(names), " ")+" ];")
+	}
+	config, err := renderNixpkgsConfig(cfg, lib)
+	if err != nil {
+		return fmt.Errorf("could not render %s: %w", nixpkgsConfigPath, err)
+	}
+	if config != "" {
+		args = append(args, "config = "+config+";")
+	}
+
+	flake, err = setPkgsImport(flake, args)
+	if err != nil {
+		return fmt.Errorf("could not apply nixpkgs import to %s: %w", filePath, err)
+	}
+
+	if len(names) == 0 {
+		flake = removeTopLevelOutput(flake, "overlays.default")
+	} else {
+		value := lib + ".composeManyExtensions [ " + strings.Join(overlayImports(names), " ") + " ]"
+		flake, err = setTopLevelOutput(flake, "overlays.default", value)
+		if err != nil {
+			return fmt.Errorf("could not export overlays in %s: %w", filePath, err)
+		}
+	}
+
+	f.content = flake
+	return nil
+}
+
+// renders the attrset passed to `import nixpkgs`
+func renderImportArgs(args []string) string {
+	return "{ " + strings.Join(append([]string{"inherit system;"}, args...), " ") + " }"
+}
+
+// sets the arguments of the pkgs import, args are `name = value;` pairs
+func setPkgsImport(flake string, args []string) (string, error) {
+	lines := strings.Split(flake, "\n")
+
+	if flakeStyle(flake) == StyleFlakeParts {
+		for i, line := range lines {
+			if m := partsPkgsImportRegex.FindStringSubmatch(line); m != nil {
+				if len(args) == 0 {
+					// flake-parts provides the default pkgs
+					return strings.Join(append(lines[:i], lines[i+1:]...), "\n"), nil
+				}
+				lines[i] = m[1] + "_module.args.pkgs = import inputs.nixpkgs " + renderImportArgs(args) + ";"
+				return strings.Join(lines, "\n"), nil
+			}
+		}
+		if len(args) == 0 {
+			return flake, nil
+		}
+		// the attrset of perSystem opens on its line, or after its let bindings
+		start := -1
+		for i, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), "perSystem =") {
+				start = i
+				break
+			}
+		}
+		for i := start; start != -1 && i < len(lines); i++ {
+			trim := strings.TrimSpace(lines[i])
+			if (i == start && strings.HasSuffix(trim, "{")) || (i > start && (trim == "in {" || trim == "in{")) {
+				newLine := getLineIndentation(lines[i]) + "  _module.args.pkgs = import inputs.nixpkgs " + renderImportArgs(args) + ";"
+				newLines := append([]string{}, lines[:i+1]...)
+				newLines = append(newLines, newLine)
+				return strings.Join(append(newLines, lines[i+1:]...), "\n"), nil
+			}
+		}
+		return "", newError(KindParse, "could not find perSystem")
+	}
+
+	for i, line := range lines {
+		if m := pkgsImportRegex.FindStringSubmatch(line); m != nil {
+			lines[i] = m[1] + "pkgs = import nixpkgs " + renderImportArgs(args) + ";"
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+	return "", newError(KindParse, "could not find `pkgs = import nixpkgs { ... };`")
+}
+
+// finds the block holding top-level outputs, returns -1 if there is none
+func findTopLevelBlock(lines []string) (int, int) {
+	start := -1
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+		if trim == ") // {" || trim == "flake = {" {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return -1, -1
+	}
+
+	indent := getLineIndentation(lines[start])
+	for i := start + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "};" && getLineIndentation(lines[i]) == indent {
+			return start, i
+		}
+	}
+	return -1, -1
+}
+
+// sets a top-level (not per-system) output such as overlays.default
+func setTopLevelOutput(flake, attr, value string) (string, error) {
+	lines := strings.Split(flake, "\n")
+	start, end := findTopLevelBlock(lines)
+
+	if start == -1 {
+		if flakeStyle(flake) == StyleFlakeParts {
+			perIdx := -1
+			for i, line := range lines {
+				if strings.HasPrefix(strings.TrimSpace(line), "perSystem =") {
+					perIdx = i
+					break
+				}
+			}
+			if perIdx == -1 {
+				return "", newError(KindParse, "could not find perSystem")
+			}
+			indent := getLineIndentation(lines[perIdx])
+			block := []string{indent + "flake = {", indent + "};", ""}
+			lines = append(lines[:perIdx], append(block, lines[perIdx:]...)...)
+			start, end = perIdx, perIdx+1
+		} else {
+			closeIdx := -1
+			for i := len(lines) - 1; i >= 0; i-- {
+				if strings.TrimSpace(lines[i]) == ");" {
+					closeIdx = i
+					break
+				}
+			}
+			if closeIdx == -1 {
+				return "", newError(KindParse, "could not find the end of the per-system outputs")
+			}
+			indent := getLineIndentation(lines[closeIdx])
+			block := []string{indent + ") // {", indent + "};"}
+			lines = append(lines[:closeIdx], append(block, lines[closeIdx+1:]...)...)
+			start, end = closeIdx, closeIdx+1
+		}
+	}
+
+	newLine := getLineIndentation(lines[start]) + "  " + attr + " = " + value + ";"
+	for i := start + 1; i < end; i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), attr+" =") {
+			lines[i] = newLine
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+
+	lines = append(lines[:end], append([]string{newLine}, lines[end:]...)...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// removes a top-level output, dropping the block once it is empty
+func removeTopLevelOutput(flake, attr string) string {
+	lines := strings.Split(flake, "\n")
+	start, end := findTopLevelBlock(lines)
+	if start == -1 {
+		return flake
+	}
+
+	for i := start + 1; i < end; i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), attr+" =") {
+			lines = append(lines[:i], lines[i+1:]...)
+			end--
+			break
+		}
+	}
+
+	for i := start + 1; i < end; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	// block is empty
+	indent := getLineIndentation(lines[start])
+	if strings.TrimSpace(lines[start]) == ") // {" {
+		lines = append(lines[:start], append([]string{indent + ");"}, lines[end+1:]...)...)
+		return strings.Join(lines, "\n")
+	}
+	// drop the blank line left after the flake-parts block
+	if end+1 < len(lines) && strings.TrimSpace(lines[end+1]) == "" {
+		end++
+	}
+	return strings.Join(append(lines[:start], lines[end+1:]...), "\n")
+}
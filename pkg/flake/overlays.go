@@ -0,0 +1,93 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const overlaysDir = ".flk/overlays"
+
+// default content for a new overlay
+var overlayBoilerplate = `final: prev: {
+
+}
+`
+
+// Overlays returns the names of the overlays in .flk/overlays, sorted
+func (f *Flake) Overlays() ([]string, error) {
+	entries, err := os.ReadDir(f.resolve(overlaysDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", overlaysDir, err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".nix") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), ".nix"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// AddOverlay creates .flk/overlays/<name>.nix, copying from when given, and applies it to pkgs
+func (f *Flake) AddOverlay(name, from string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	if name == "" || strings.ContainsAny(name, "/\\ ") {
+		return fmt.Errorf("invalid overlay name %q", name)
+	}
+
+	path := f.resolve(filepath.Join(overlaysDir, name+".nix"))
+	if _, err := os.Stat(path); err == nil {
+		return newError(KindAlreadyExists, "overlay %s already exists", name)
+	}
+
+	content := []byte(overlayBoilerplate)
+	if from != "" {
+		var err error
+		content, err = os.ReadFile(from)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", from, err)
+		}
+	}
+
+	if err := os.MkdirAll(f.resolve(overlaysDir), 0755); err != nil {
+		return fmt.Errorf("could not create %s folder: %w", overlaysDir, err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return f.applyPkgsImport()
+}
+
+// RemoveOverlay deletes .flk/overlays/<name>.nix and drops it from pkgs
+func (f *Flake) RemoveOverlay(name string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	path := f.resolve(filepath.Join(overlaysDir, name+".nix"))
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return newError(KindNotFound, "overlay %s not found", name)
+		}
+		return fmt.Errorf("could not remove %s: %w", path, err)
+	}
+	return f.applyPkgsImport()
+}
+
+// nix expressions importing every overlay
+func overlayImports(names []string) []string {
+	imports := make([]string, len(names))
+	for i, n := range names {
+		imports[i] = fmt.Sprintf("(import ./%s/%s.nix)", overlaysDir, n)
+	}
+	return imports
+}
@@ -0,0 +1,51 @@
+package flake
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOverlays(t *testing.T) {
+	for _, style := range []string{StyleFlakeUtils, StyleFlakeParts} {
+		f := newTestFlake(t, style)
+		for _, name := range []string{"rust", "python"} {
+			if err := f.AddOverlay(name, ""); err != nil {
+				t.Fatalf("%s: add overlay %s: %v", style, name, err)
+			}
+		}
+		if err := f.AddOverlay("rust", ""); KindOf(err) != KindAlreadyExists {
+			t.Errorf("%s: adding rust twice gave %v, want already-exists", style, err)
+		}
+
+		names, err := f.Overlays()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"python", "rust"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: overlays are %q, want %q", style, names, want)
+		}
+		imports := "overlays = [ (import ./.flk/overlays/python.nix) (import ./.flk/overlays/rust.nix) ];"
+		if strings.Count(f.String(), imports) != 1 {
+			t.Errorf("%s: pkgs does not import the overlays once:\n%s", style, f.String())
+		}
+		if !strings.Contains(f.String(), "overlays.default = ") {
+			t.Errorf("%s: the flake lacks overlays.default:\n%s", style, f.String())
+		}
+		if err := checkNixSyntax(f.String()); err != nil {
+			t.Errorf("%s: %v", style, err)
+		}
+
+		for _, name := range names {
+			if err := f.RemoveOverlay(name); err != nil {
+				t.Fatalf("%s: remove overlay %s: %v", style, name, err)
+			}
+		}
+		if strings.Contains(f.String(), "overlays") {
+			t.Errorf("%s: removing every overlay kept them in the flake:\n%s", style, f.String())
+		}
+		if err := f.RemoveOverlay("rust"); KindOf(err) != KindNotFound {
+			t.Errorf("%s: removing a missing overlay gave %v, want not-found", style, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package flake
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Package is a devShell package and the nix condition guarding it, empty if unconditional
+type Package struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// matches `(pkgs.lib.optionals <condition> [ pkgs.a pkgs.b ])`
+var conditionalLineRegex = regexp.MustCompile(`^\(\s*(?:pkgs\.)?lib\.optionals\s+(.+?)\s+\[(.*)\]\s*\)$`)
+
+// matches the condition built for --only-system
+var systemConditionRegex = regexp.MustCompile(`^\(pkgs\.stdenv\.hostPlatform\.system == "(.+)"\)$`)
+
+// conditions for --platform
+var platformConditions = map[string]string{
+	"linux":  "pkgs.stdenv.isLinux",
+	"darwin": "pkgs.stdenv.isDarwin",
+}
+
+// PackageCondition builds the nix condition for a package only added on platform (linux or darwin) or system
+func PackageCondition(platform, system string) (string, error) {
+	if platform != "" && system != "" {
+		return "", newError(KindConflict, "--platform and --only-system cannot be combined")
+	}
+	if platform != "" {
+		cond, ok := platformConditions[platform]
+		if !ok {
+			return "", newError(KindUsage, "unknown platform %q, expected linux or darwin", platform)
+		}
+		return cond, nil
+	}
+	if system != "" {
+		if err := validateSystems([]string{system}); err != nil {
+			return "", newError(KindUsage, "%w", err)
+		}
+		return fmt.Sprintf("(pkgs.stdenv.hostPlatform.system == %q)", system), nil
+	}
+	return "", nil
+}
+
+// DescribeCondition turns a condition back into the platform or system it was made from
+func DescribeCondition(cond string) string {
+	for platform, c := range platformConditions {
+		if c == cond {
+			return platform
+		}
+	}
+	if m := systemConditionRegex.FindStringSubmatch(cond); m != nil {
+		return m[1]
+	}
+	return cond
+}
+
+// ConditionHolds reports whether a package with cond is added on system, false for conditions flk didn't build
+func ConditionHolds(cond, system string) bool {
+	if cond == "" {
+		return true
+	}
+	switch described := DescribeCondition(cond); described {
+	case "linux", "darwin":
+		return strings.HasSuffix(system, "-"+described)
+	default:
+		return described == system
+	}
+}
+
+// parses a conditional line of the packages list
+func parseConditionalLine(line string) (string, []string, bool) {
+	m := conditionalLineRegex.FindStringSubmatch(line)
+	if m == nil {
+		return "", nil, false
+	}
+	return m[1], strings.Fields(m[2]), true
+}
+
+func formatConditionalLine(cond string, names []string) string {
+	return fmt.Sprintf("(pkgs.lib.optionals %s [ %s ])", cond, strings.Join(names, " "))
+}
+
+// adds pkg to the group for condition, creating the group at the end of the list if needed
+func addConditionalPackage(lines []string, blockStart, blockEnd int, indent, pkg, condition string) []string {
+	newLines := append([]string{}, lines...)
+	for i := blockStart + 1; i < blockEnd; i++ {
+		cond, names, ok := parseConditionalLine(strings.TrimSpace(lines[i]))
+		if ok && cond == condition {
+			newLines[i] = getLineIndentation(lines[i]) + formatConditionalLine(cond, append(names, pkg))
+			return newLines
+		}
+	}
+
+	newLines = append([]string{}, lines[:blockEnd]...)
+	newLines = append(newLines, indent+formatConditionalLine(condition, []string{pkg}))
+	return append(newLines, lines[blockEnd:]...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
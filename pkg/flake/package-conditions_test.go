@@ -0,0 +1,64 @@
+package flake
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConditionalPackages(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	linux, err := PackageCondition("linux", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aarch64, err := PackageCondition("", "aarch64-linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Package{{"jq", ""}, {"strace", linux}, {"gdb", linux}, {"steam-run", aarch64}} {
+		if err := f.AddPackage(p.Name, p.Condition); err != nil {
+			t.Fatalf("add %s: %v", p.Name, err)
+		}
+	}
+
+	want := []Package{{"pkgs.jq", ""}, {"pkgs.strace", linux}, {"pkgs.gdb", linux}, {"pkgs.steam-run", aarch64}}
+	got, err := f.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packages are %+v, want %+v", got, want)
+	}
+	if !strings.Contains(f.String(), "(pkgs.lib.optionals pkgs.stdenv.isLinux [ pkgs.strace pkgs.gdb ])") {
+		t.Errorf("strace and gdb are not in one linux group:\n%s", f.String())
+	}
+	if err := checkNixSyntax(f.String()); err != nil {
+		t.Error(err)
+	}
+
+	// a group entry is only removed by its whole name
+	if err := f.RemovePackage("gd"); KindOf(err) != KindNotFound {
+		t.Errorf("removing gd gave %v, want not found", err)
+	}
+
+	// removing the last package of a group removes the group
+	for _, name := range []string{"gdb", "strace"} {
+		if err := f.RemovePackage(name); err != nil {
+			t.Fatalf("remove %s: %v", name, err)
+		}
+	}
+	if strings.Contains(f.String(), "isLinux") {
+		t.Errorf("the empty linux group was kept:\n%s", f.String())
+	}
+
+	if _, err := PackageCondition("linux", "x86_64-linux"); KindOf(err) != KindConflict {
+		t.Errorf("combining a platform and a system gave %v, want a conflict", err)
+	}
+	if _, err := PackageCondition("windows", ""); KindOf(err) != KindUsage {
+		t.Errorf("an unknown platform gave %v, want a usage error", err)
+	}
+	if _, err := PackageCondition("", "linux"); KindOf(err) != KindUsage {
+		t.Errorf("an invalid system gave %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,369 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+func (f *Flake) getPackagesFromPackageYML() ([]string, error) {
+	ymlPath := f.resolve(packageYAMLPath)
+	yf, err := os.Open(ymlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer yf.Close()
+
+	type pkgFile struct {
+		Packages []string `yaml:"packages"`
+	}
+	var pf pkgFile
+
+	dec := yaml.NewDecoder(yf)
+	if err := dec.Decode(&pf); err != nil {
+		return nil, err
+	}
+
+	var pkgs []string
+	for _, p := range pf.Packages {
+		pkgs = append(pkgs, "pkgs."+p)
+	}
+	return pkgs, nil
+}
+
+func (f *Flake) getPackages() ([]string, error) {
+	entries, err := f.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []string
+	for _, e := range entries {
+		packages = append(packages, e.Name)
+	}
+	return packages, nil
+}
+
+// opens the devShell packages list, optionally written `with pkgs;`
+var packagesListRegex = regexp.MustCompile(`packages = (with pkgs; )?\[`)
+
+// Packages returns the devShell packages with the condition attached to each
+func (f *Flake) Packages() ([]Package, error) {
+	loc := packagesListRegex.FindStringIndex(f.content)
+	if loc == nil {
+		return nil, nil
+	}
+	open := loc[1] - 1
+	end, err := scanNixExpr(f.content, open+1, "]")
+	if err != nil {
+		return nil, newError(KindParse, "could not parse the packages list in %s: %w", f.Path, err)
+	}
+	packages, ok := parsePackageList(f.content[loc[0]+len("packages = ") : end+1])
+	if !ok {
+		return nil, newError(KindParse, "could not parse the packages list in %s", f.Path)
+	}
+	return packages, nil
+}
+
+// parses a list of packages, a `with pkgs;` prefix included. Each name of a conditional group gets the
+// condition attached, and entries that are not attribute names, like calls, are left out.
+func parsePackageList(expr string) ([]Package, bool) {
+	items, ok := parseNixList(withPrefixRegex.ReplaceAllString(strings.TrimSpace(expr), ""))
+	if !ok {
+		return nil, false
+	}
+	var packages []Package
+	for _, item := range items {
+		// groups may be split over several lines
+		if cond, names, ok := parseConditionalLine(strings.Join(strings.Fields(item), " ")); ok {
+			for _, n := range names {
+				packages = append(packages, Package{Name: n, Condition: cond})
+			}
+			continue
+		}
+		if pkgAttrRegex.MatchString(item) {
+			packages = append(packages, Package{Name: item})
+		}
+	}
+	return packages, true
+}
+
+// AddPackage adds pkg to the devShell, guarded by condition when it is not empty
+func (f *Flake) AddPackage(pkg, condition string) error {
+	input := f.content
+
+	// get let variable
+	variables := getVariables(input)
+
+	// search pkgs prefix
+	prefixFound := false
+	for _, v := range variables {
+		// pkgs defined?
+		if strings.HasPrefix(v, "pkgs") {
+			prefixFound = true
+			break
+		}
+	}
+
+	// flake-parts passes pkgs to perSystem, and the workspace root to its members
+	if flakeStyle(input) == StyleFlakeParts || f.IsMember() {
+		prefixFound = true
+	}
+
+	if !prefixFound {
+		// insert pkgs if missing
+		var err error
+		input, err = insertVariable(input, "pkgs", "import nixpkgs { inherit system; }")
+		if err != nil {
+			return err
+		}
+	}
+
+	present, err := f.Packages()
+	if err != nil {
+		return err
+	}
+	for _, p := range present {
+		if isPackageEntry(p.Name, pkg) {
+			return newError(KindAlreadyExists, "package already exists: %s", pkg)
+		}
+	}
+
+	lines := strings.Split(input, "\n")
+	var (
+		blockStartIndex = -1
+		blockEndIndex   = -1
+		indent          = ""
+	)
+
+	// add prefix, lists written `with pkgs;` take bare names
+	fullPkgName := "pkgs." + pkg
+	// Find the packages block
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+
+		if packagesListRegex.MatchString(trim) {
+			if strings.Contains(trim, "with pkgs;") {
+				fullPkgName = pkg
+			}
+			blockStartIndex = i
+			indent = getLineIndentation(line) + indentUnit(f.content)
+			continue
+		}
+
+		if blockStartIndex != -1 && strings.HasPrefix(trim, "]") {
+			blockEndIndex = i // end block
+			break
+		}
+	}
+
+	// If block not found, create and retry
+	if blockStartIndex == -1 || blockEndIndex == -1 {
+		withBlock, err := createBlock(input, "mkShell", "packages = [", "]")
+		if err != nil {
+			return err
+		}
+		f.content = withBlock
+		return f.AddPackage(pkg, condition)
+	}
+
+	var newLines []string
+	if condition == "" {
+		newLines = append([]string{}, lines[:blockEndIndex]...)
+		newLines = append(newLines, indent+fullPkgName)
+		newLines = append(newLines, lines[blockEndIndex:]...)
+	} else {
+		newLines = addConditionalPackage(lines, blockStartIndex, blockEndIndex, indent, fullPkgName, condition)
+	}
+
+	f.content = strings.Join(newLines, "\n")
+	return nil
+}
+
+// reports whether a packages list entry, written with or without the pkgs. prefix, is pkg
+func isPackageEntry(entry, pkg string) bool {
+	return strings.TrimPrefix(entry, "pkgs.") == strings.TrimPrefix(pkg, "pkgs.")
+}
+
+// returns the entries of a packages list line other than pkg
+func withoutPackage(entries []string, pkg string) []string {
+	var kept []string
+	for _, e := range entries {
+		if !pkgAttrRegex.MatchString(e) || !isPackageEntry(e, pkg) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func getLineIndentation(line string) string {
+	for i, r := range line {
+		if !unicode.IsSpace(r) {
+			return line[:i]
+		}
+	}
+	return ""
+}
+
+// RemovePackage removes pkg from the devShell
+func (f *Flake) RemovePackage(pkg string) error {
+	lines := strings.Split(f.content, "\n")
+	packageFound := false
+	newLines := []string{}
+
+	// Remove the package entry from the packages list
+	inBlock := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if packagesListRegex.MatchString(trimmed) || strings.HasPrefix(trimmed, "]") {
+			inBlock = !strings.HasPrefix(trimmed, "]")
+			newLines = append(newLines, line)
+			continue
+		}
+		if !inBlock || strings.HasPrefix(trimmed, "#") {
+			newLines = append(newLines, line)
+			continue
+		}
+
+		// conditional groups and plain lines may hold several entries
+		cond, entries, conditional := parseConditionalLine(trimmed)
+		if !conditional {
+			entries = strings.Fields(trimmed)
+		}
+		kept := withoutPackage(entries, pkg)
+		switch {
+		case len(kept) == len(entries):
+			newLines = append(newLines, line)
+		case len(kept) > 0 && conditional:
+			newLines = append(newLines, getLineIndentation(line)+formatConditionalLine(cond, kept))
+		case len(kept) > 0:
+			newLines = append(newLines, getLineIndentation(line)+strings.Join(kept, " "))
+		}
+		if len(kept) != len(entries) {
+			packageFound = true
+		}
+	}
+
+	if !packageFound {
+		return newError(KindNotFound, "package not found: %s", pkg)
+	}
+
+	f.content = strings.Join(newLines, "\n")
+	return nil
+}
+
+func getVariables(flake string) []string {
+	lines := strings.Split(flake, "\n")
+	var variables []string
+	inLetBlock := false
+
+	varDeclRegex := regexp.MustCompile(`^\s*([a-zA-Z0-9_.-]+)\s*=`)
+
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trim, "let") {
+			inLetBlock = true
+			continue
+		}
+		if inLetBlock && strings.HasPrefix(trim, "in") {
+			inLetBlock = false
+			continue
+		}
+		if inLetBlock {
+			if strings.HasPrefix(trim, "#") || trim == "" {
+				continue
+			}
+			matches := varDeclRegex.FindStringSubmatch(trim)
+			if len(matches) == 2 {
+				varName := matches[1]
+				variables = append(variables, varName)
+			}
+		}
+	}
+
+	return variables
+}
+
+func insertVariable(flake, varName, varValue string) (string, error) {
+	lines := strings.Split(flake, "\n")
+	letIndex := -1
+	inIndex := -1
+
+	// prefer the let block whose body opens the per-system attrset
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+		if strings.HasPrefix(trim, "in {") || strings.HasPrefix(trim, "in{") {
+			inIndex = i
+			break
+		}
+	}
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+		if inIndex != -1 && i >= inIndex {
+			break
+		}
+		if strings.HasPrefix(trim, "let") {
+			letIndex = i
+		} else if inIndex == -1 && (trim == "in" || strings.HasPrefix(trim, "in ")) {
+			inIndex = i
+			break
+		}
+	}
+
+	if letIndex == -1 || inIndex == -1 {
+		return "", newError(KindParse, "could not find 'let ... in' block")
+	}
+
+	indent := ""
+	for i := letIndex + 1; i < inIndex; i++ {
+		trim := strings.TrimSpace(lines[i])
+		if trim != "" && !strings.HasPrefix(trim, "#") {
+			indent = getLineIndentation(lines[i])
+			break
+		}
+	}
+
+	if indent == "" {
+		indent = getLineIndentation(lines[letIndex]) + "  "
+	}
+
+	newLine := fmt.Sprintf("%s%s = %s;", indent, varName, varValue)
+	newLines := append(lines[:inIndex], append([]string{newLine}, lines[inIndex:]...)...)
+
+	return strings.Join(newLines, "\n"), nil
+}
+
+// updates a field in the defaultPackage block of flake.nix
+func updateFieldInDefaultPackage(flake, field, value string) string {
+	// Find defaultPackage = pkgs.stdenv.mkDerivation {
+	blockStart := strings.Index(flake, derivationAttr(flake)+" = pkgs.stdenv.mkDerivation {")
+	if blockStart == -1 {
+		return flake
+	}
+	// Find the end of the block
+	blockEnd := strings.Index(flake[blockStart:], "};")
+	if blockEnd == -1 {
+		return flake
+	}
+	blockEnd += blockStart
+	block := flake[blockStart:blockEnd]
+
+	// Find the field line
+	fieldStart := strings.Index(block, field+" = ")
+	if fieldStart == -1 {
+		return flake
+	}
+	fieldEnd := strings.Index(block[fieldStart:], ";")
+	if fieldEnd == -1 {
+		return flake
+	}
+	fieldEnd += fieldStart
+	// Replace the value
+	newBlock := block[:fieldStart+len(field+" = ")] + value + block[fieldEnd:]
+	return flake[:blockStart] + newBlock + flake[blockEnd:]
+}
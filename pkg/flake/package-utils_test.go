@@ -0,0 +1,42 @@
+package flake
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackagesMatchWholeNames(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	for _, name := range []string{"gopls", "nodejs_20"} {
+		if err := f.AddPackage(name, ""); err != nil {
+			t.Fatalf("add %s: %v", name, err)
+		}
+	}
+
+	// go and nodejs are not gopls and nodejs_20
+	for _, name := range []string{"go", "nodejs"} {
+		if err := f.RemovePackage(name); KindOf(err) != KindNotFound {
+			t.Errorf("removing %s gave %v, want not found", name, err)
+		}
+		if err := f.AddPackage(name, ""); err != nil {
+			t.Errorf("add %s: %v", name, err)
+		}
+	}
+	if err := f.AddPackage("go", ""); KindOf(err) != KindAlreadyExists {
+		t.Errorf("adding go twice gave %v, want already exists", err)
+	}
+
+	for _, name := range []string{"go", "nodejs"} {
+		if err := f.RemovePackage(name); err != nil {
+			t.Fatalf("remove %s: %v", name, err)
+		}
+	}
+	want := []Package{{Name: "pkgs.gopls"}, {Name: "pkgs.nodejs_20"}}
+	got, err := f.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packages are %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package flake
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// GenerateInputs rewrites the inputs block, adding the inputs the outputs use. Workspace members are left as is.
+func (f *Flake) GenerateInputs() error {
+	// members take pkgs from the workspace root
+	if f.IsMember() {
+		return nil
+	}
+	var newLines []string
+	var extraInputs []string
+	var nixConfigLines []string
+	skipBlock := false
+	inNixConfig := false
+	skipBlank := false
+	description := ""
+	seenOutputs := false
+
+	scanner := bufio.NewScanner(strings.NewReader(f.content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trim := strings.TrimSpace(line)
+		condensed := strings.Join(strings.Fields(trim), "")
+
+		// keep nixConfig, it is re-inserted after the inputs block
+		if inNixConfig {
+			nixConfigLines = append(nixConfigLines, line)
+			if condensed == "};" {
+				inNixConfig = false
+				skipBlank = true
+			}
+			continue
+		}
+		if strings.HasPrefix(condensed, "nixConfig={") {
+			nixConfigLines = append(nixConfigLines, line)
+			inNixConfig = !strings.HasSuffix(condensed, "};")
+			skipBlank = !inNixConfig
+			continue
+		}
+		if skipBlank {
+			skipBlank = false
+			if trim == "" {
+				continue
+			}
+		}
+
+		// keep the description, it goes above the inputs block
+		if strings.HasPrefix(condensed, "outputs") {
+			seenOutputs = true
+		}
+		if !seenOutputs && description == "" && strings.HasPrefix(condensed, "description=") && strings.HasSuffix(condensed, ";") {
+			description = line
+			skipBlank = true
+			continue
+		}
+
+		// parse inputs block
+		if skipBlock {
+			// end of block?
+			if condensed == "};" {
+				skipBlock = false
+				continue
+			}
+			// preserve input line
+			if trim != "" {
+				extraInputs = append(extraInputs, trim)
+			}
+			continue
+		}
+
+		// start of inputs block
+		if strings.HasPrefix(condensed, "inputs={") {
+			skipBlock = true
+			if strings.HasSuffix(condensed, "};") {
+				skipBlock = false
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trim, "inputs.") {
+			parts := strings.SplitN(line, ".", 2)
+			if len(parts) == 2 {
+				extraInputs = append(extraInputs, strings.TrimSpace(parts[1]))
+			}
+			continue
+		}
+
+		// regular line
+		newLines = append(newLines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read %s: %w", f.Path, err)
+	}
+
+	// keep the nixpkgs the flake follows, new flakes get the configured one
+	nixpkgsURL := f.Options.NixpkgsURL
+	if nixpkgsURL == "" {
+		nixpkgsURL = defaultNixpkgsURL
+	}
+	nixpkgsLine := "nixpkgs.url = " + nixString(nixpkgsURL) + ";"
+	for _, inp := range extraInputs {
+		if condensed := strings.Join(strings.Fields(inp), ""); strings.HasPrefix(condensed, "nixpkgs.url=") {
+			nixpkgsLine = strings.TrimSpace(inp)
+			break
+		}
+	}
+
+	// build block
+	inputBlock := []string{
+		"  inputs = {",
+		"    " + nixpkgsLine,
+	}
+	// only pull flake-utils and flake-parts when outputs use them
+	body := strings.Join(newLines, "\n")
+	if strings.Contains(body, "flake-utils") {
+		inputBlock = append(inputBlock, `    flake-utils.url = "github:numtide/flake-utils";`)
+	}
+	if strings.Contains(body, "flake-parts") {
+		inputBlock = append(inputBlock, `    flake-parts.url = "github:hercules-ci/flake-parts";`)
+	}
+
+	// anti-duplicate
+	for _, inp := range extraInputs {
+		trimmedInp := strings.TrimSpace(inp)
+		if strings.HasPrefix(trimmedInp, "nixpkgs.") || strings.HasPrefix(trimmedInp, "flake-utils.") || strings.HasPrefix(trimmedInp, "flake-parts.") {
+			continue
+		}
+		inputBlock = append(inputBlock, "    "+trimmedInp)
+	}
+	inputBlock = append(inputBlock, "  };")
+	if nixConfigLines != nil {
+		if block := renderNixConfig(parseNixConfig(nixConfigLines)); block != nil {
+			inputBlock = append(inputBlock, "")
+			inputBlock = append(inputBlock, block...)
+		}
+	}
+
+	if description != "" {
+		inputBlock = append([]string{description, ""}, inputBlock...)
+	}
+
+	// Insert the new block right after the {
+	finalLines := []string{}
+	inserted := false
+	for _, l := range newLines {
+		finalLines = append(finalLines, l)
+		if !inserted && strings.TrimSpace(l) == "{" {
+			finalLines = append(finalLines, inputBlock...)
+			inserted = true
+		}
+	}
+
+	f.content = strings.Join(finalLines, "\n")
+	return nil
+}
+
+func createBlock(flake string, location string, startBlock string, endBlock string) (string, error) {
+	lines := strings.Split(flake, "\n")
+
+	var newLines []string
+	blockInserted := false
+
+	// loop over lines and insert text
+	for _, line := range lines {
+		newLines = append(newLines, line) // Keep the original line
+		if strings.Contains(line, location) && !blockInserted {
+			indent := getLineIndentation(line)
+			newLines = append(newLines, fmt.Sprintf("%s  %s", indent, startBlock))
+			newLines = append(newLines, fmt.Sprintf("%s  %s", indent, endBlock))
+			blockInserted = true
+		}
+	}
+
+	if !blockInserted {
+		return "", newError(KindParse, "location '%s' not found in file", location)
+	}
+
+	return strings.Join(newLines, "\n"), nil
+}
@@ -0,0 +1,93 @@
+package flake
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DevShell is a mkShell output of the flake
+type DevShell struct {
+	Name      string    `json:"name"`
+	Packages  []Package `json:"packages"`
+	ShellHook string    `json:"shellHook,omitempty"`
+}
+
+// Derivation is the mkDerivation output of the flake
+type Derivation struct {
+	Pname        string   `json:"pname"`
+	Version      string   `json:"version"`
+	Src          string   `json:"src"`
+	BuildInputs  []string `json:"buildInputs"`
+	BuildPhase   string   `json:"buildPhase,omitempty"`
+	InstallPhase string   `json:"installPhase,omitempty"`
+}
+
+// matches `default = pkgs.mkShell {` and `devShells.default = pkgs.mkShell {`
+var devShellRegex = regexp.MustCompile(`(?m)^\s*([a-zA-Z0-9_."-]+)\s*=\s*pkgs\.mkShell\s*\{`)
+
+// DevShells returns the devShells of the flake in the order they are declared
+func (f *Flake) DevShells() ([]DevShell, error) {
+	var shells []DevShell
+	for _, m := range devShellRegex.FindAllStringSubmatchIndex(f.content, -1) {
+		name := strings.Trim(strings.TrimPrefix(f.content[m[2]:m[3]], "devShells."), `"`)
+		end, err := scanNixExpr(f.content, m[1], "}")
+		if err != nil {
+			return nil, newError(KindParse, "could not parse devShell %s in %s: %w", name, f.Path, err)
+		}
+		attrs, _, err := parseNixAttrs(f.content[m[1]:end])
+		if err != nil {
+			return nil, newError(KindParse, "could not parse devShell %s in %s: %w", name, f.Path, err)
+		}
+
+		shell := DevShell{Name: name, Packages: []Package{}}
+		for _, a := range attrs {
+			switch {
+			case packageAttrs[a.Name]:
+				packages, _ := parsePackageList(a.Value)
+				shell.Packages = append(shell.Packages, packages...)
+			case a.Name == "shellHook":
+				shell.ShellHook, _ = parseNixScript(a.Value)
+			}
+		}
+		shells = append(shells, shell)
+	}
+	return shells, nil
+}
+
+// Derivation returns the mkDerivation output, nil when the flake has none
+func (f *Flake) Derivation() (*Derivation, error) {
+	body, ok := findCallAttrs(f.content, "mkDerivation")
+	if !ok {
+		return nil, nil
+	}
+	attrs, _, err := parseNixAttrs(body)
+	if err != nil {
+		return nil, newError(KindParse, "could not parse mkDerivation in %s: %w", f.Path, err)
+	}
+
+	drv := &Derivation{BuildInputs: []string{}}
+	for _, a := range attrs {
+		switch a.Name {
+		case "pname":
+			drv.Pname, _ = parseNixString(a.Value)
+		case "version":
+			drv.Version, _ = parseNixString(a.Value)
+		case "src":
+			drv.Src = a.Value
+		case "buildInputs":
+			if items, ok := parseNixList(a.Value); ok {
+				drv.BuildInputs = append(drv.BuildInputs, items...)
+			}
+		case "buildPhase":
+			drv.BuildPhase, _ = parseNixScript(a.Value)
+		case "installPhase":
+			drv.InstallPhase, _ = parseNixScript(a.Value)
+		}
+	}
+	return drv, nil
+}
+
+// PackageYAML reads .flk/derivation/package.yml
+func (f *Flake) PackageYAML() (PackageYAML, error) {
+	return f.readPackageYAML()
+}
@@ -0,0 +1,74 @@
+package flake
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackagesAndDevShellsAgree(t *testing.T) {
+	content := `{
+  outputs = { self, nixpkgs, flake-utils }:
+    flake-utils.lib.eachDefaultSystem (system:
+      let
+        pkgs = import nixpkgs { inherit system; };
+      in {
+        devShells = {
+          default = pkgs.mkShell {
+            packages = [
+              # formatters
+              pkgs.jq # json
+              pkgs.yq
+              (pkgs.lib.optionals pkgs.stdenv.isLinux [ pkgs.strace pkgs.gdb ])
+              (pkgs.lib.optionals
+                pkgs.stdenv.isDarwin [ pkgs.cocoapods ])
+              (pkgs.callPackage ./tool.nix { })
+            ];
+          };
+        };
+      }
+    );
+}
+`
+	want := []Package{
+		{Name: "pkgs.jq"},
+		{Name: "pkgs.yq"},
+		{Name: "pkgs.strace", Condition: "pkgs.stdenv.isLinux"},
+		{Name: "pkgs.gdb", Condition: "pkgs.stdenv.isLinux"},
+		{Name: "pkgs.cocoapods", Condition: "pkgs.stdenv.isDarwin"},
+	}
+	f := &Flake{Path: "flake.nix", content: content}
+
+	packages, err := f.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("Packages() = %+v, want %+v", packages, want)
+	}
+	shells, err := f.DevShells()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shells) != 1 || !reflect.DeepEqual(shells[0].Packages, want) {
+		t.Errorf("DevShells() = %+v, want one shell with %+v", shells, want)
+	}
+}
+
+func TestParsePackageList(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []Package
+		ok   bool
+	}{
+		{"[ ]", nil, true},
+		{"with pkgs; [ go gopls ]", []Package{{Name: "go"}, {Name: "gopls"}}, true},
+		{"[ pkgs.go /* pinned */ pkgs.nodejs_20 ]", []Package{{Name: "pkgs.go"}, {Name: "pkgs.nodejs_20"}}, true},
+		{"pkgs.go", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parsePackageList(tt.expr)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePackageList(%q) = %+v, %v, want %+v, %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package flake
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// output styles flk can manage
+const (
+	// StyleFlakeUtils uses flake-utils.lib.eachDefaultSystem or a genAttrs helper
+	StyleFlakeUtils = "flake-utils"
+	// StyleFlakeParts uses flake-parts.lib.mkFlake with perSystem
+	StyleFlakeParts = "flake-parts"
+)
+
+// Boilerplate content for new flake.nix
+var boilerplateContent = `
+{
+  inputs = {
+    nixpkgs.url = "github:NixOS/nixpkgs/nixos-unstable";
+    flake-utils.url = "github:numtide/flake-utils";
+  };
+
+  outputs = { self, nixpkgs, flake-utils, ... }:
+    flake-utils.lib.eachDefaultSystem (system:
+      let
+        pkgs = import nixpkgs { inherit system; };
+      in {
+        devShells = {
+          default = pkgs.mkShell {
+            packages = [
+
+            ];
+
+            shellHook = ''
+              echo "Development environment loaded"
+            '';
+          };
+        };
+      }
+    );
+}
+`
+
+// Boilerplate content for new flake-parts flake.nix
+var flakePartsBoilerplateContent = `
+{
+  inputs = {
+    nixpkgs.url = "github:NixOS/nixpkgs/nixos-unstable";
+    flake-parts.url = "github:hercules-ci/flake-parts";
+  };
+
+  outputs = inputs@{ flake-parts, ... }:
+    flake-parts.lib.mkFlake { inherit inputs; } {
+      systems = [ "x86_64-linux" "aarch64-linux" "x86_64-darwin" "aarch64-darwin" ];
+
+      perSystem = { pkgs, system, ... }: {
+        devShells.default = pkgs.mkShell {
+          packages = [
+
+          ];
+
+          shellHook = ''
+            echo "Development environment loaded"
+          '';
+        };
+      };
+    };
+}
+`
+
+// detects the output style of a flake
+func flakeStyle(flake string) string {
+	if strings.Contains(flake, "flake-parts.lib.mkFlake") || strings.Contains(flake, "perSystem =") {
+		return StyleFlakeParts
+	}
+	return StyleFlakeUtils
+}
+
+// returns the boilerplate for a style
+func boilerplateForStyle(style string) (string, error) {
+	switch style {
+	case "", StyleFlakeUtils:
+		return boilerplateContent, nil
+	case StyleFlakeParts:
+		return flakePartsBoilerplateContent, nil
+	}
+	return "", fmt.Errorf("unknown style %q, expected %s or %s", style, StyleFlakeUtils, StyleFlakeParts)
+}
+
+// attribute that holds the flk derivation
+func derivationAttr(flake string) string {
+	if flakeStyle(flake) == StyleFlakeParts {
+		return "packages.default"
+	}
+	return "defaultPackage"
+}
+
+// per-system outputs flake-parts declares without extra modules
+var partsPerSystemOptions = map[string]bool{
+	"apps": true, "checks": true, "devShells": true, "formatter": true, "legacyPackages": true, "packages": true,
+}
+
+var (
+	eachSystemCallRegex = regexp.MustCompile(`^(flake-utils\.lib\.eachDefaultSystem|flake-utils\.lib\.eachSystem\s*\[[^\]]*\]|eachSystem)\s*\(\s*system\s*:`)
+	mkFlakeCallRegex    = regexp.MustCompile(`^flake-parts\.lib\.mkFlake\s*`)
+)
+
+// the parts of the outputs Convert carries over, binding texts are kept verbatim
+type flakeOutputs struct {
+	at        string   // name bound to the inputs with @
+	args      []string // arguments of outputs other than flake-utils, flake-parts and ...
+	bindings  []string // per-system let bindings other than pkgs
+	perSystem []string
+	topLevel  []string
+	skipped   []string // what can't be carried over
+	uses      string   // carried over text other than the outputs flk renders itself
+}
+
+// outputs flk renders itself, they don't decide which arguments outputs keeps
+var managedOutputs = map[string]bool{
+	"packages.image": true, "overlays.default": true, "nixosModules.default": true, "homeManagerModules.default": true,
+}
+
+// Convert rewrites a flk managed flake between the flake-utils and flake-parts styles, only the per-system
+// wrapper of the outputs changes
+func (f *Flake) Convert(style string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	if _, err := boilerplateForStyle(style); err != nil {
+		return err
+	}
+
+	filePath := f.Path
+	flake := f.content
+	from := flakeStyle(flake)
+
+	if from == style {
+		return nil
+	}
+
+	systems, err := f.Systems()
+	if err != nil {
+		systems = flakeUtilsDefaultSystems
+	}
+
+	open := skipNixSpace(flake, 0)
+	if open >= len(flake) || flake[open] != '{' {
+		return newError(KindParse, "could not parse %s: expected {", filePath)
+	}
+	close, err := scanNixExpr(flake, open+1, "}")
+	if err != nil {
+		return newError(KindParse, "could not parse %s: %v", filePath, err)
+	}
+	top, _, err := scanNixBindings(flake, open+1, close, false)
+	if err != nil {
+		return newError(KindParse, "could not parse %s: %v", filePath, err)
+	}
+	var outputs *nixBinding
+	for i := range top {
+		if top[i].Name == "outputs" {
+			outputs = &top[i]
+		}
+	}
+	if outputs == nil {
+		return newError(KindParse, "could not find outputs in %s", filePath)
+	}
+
+	var out *flakeOutputs
+	if from == StyleFlakeParts {
+		out, err = parsePartsOutputs(outputs.Value())
+	} else {
+		out, err = parseUtilsOutputs(outputs.Value(), style)
+	}
+	if err != nil {
+		return newError(KindParse, "could not parse the outputs of %s: %v", filePath, err)
+	}
+	if len(out.skipped) > 0 {
+		return newError(KindConflict, "cannot convert %s to %s, these can't be carried over: %s", filePath, style, strings.Join(out.skipped, ", "))
+	}
+
+	var value string
+	if style == StyleFlakeParts {
+		value = out.renderParts(systems)
+	} else {
+		value = out.renderUtils()
+	}
+	newFlake := flake[:outputs.value] + " " + value + flake[outputs.end-1:]
+
+	// eachDefaultSystem only covers the flake-utils defaults
+	if style == StyleFlakeUtils && !sameSystems(systems, flakeUtilsDefaultSystems) {
+		newFlake, err = withSystems(newFlake, systems)
+		if err != nil {
+			return err
+		}
+	}
+
+	// carry overlays and the nixpkgs config over to the new pkgs binding, and the image, modules and
+	// workspace members to the new outputs
+	f.content = newFlake
+	if err := f.applyPkgsImport(); err != nil {
+		return err
+	}
+	if err := f.applyImage(); err != nil {
+		return err
+	}
+	if err := f.applyModules(); err != nil {
+		return err
+	}
+	return f.applyWorkspace()
+}
+
+// splits flake-utils outputs, converting them for style
+func parseUtilsOutputs(value, style string) (*flakeOutputs, error) {
+	at, args, i, err := parseNixLambdaHead(value, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := &flakeOutputs{at: at, args: args}
+
+	// the systems helper written by withSystems
+	i = skipNixSpace(value, i)
+	if hasNixKeyword(value, i, "let") {
+		var bindings []nixBinding
+		bindings, i, err = scanNixBindings(value, i+3, len(value), true)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bindings {
+			switch b.Name {
+			case "systems", "forAllSystems", "eachSystem":
+			default:
+				out.skip("let binding " + b.Name)
+			}
+		}
+		i = skipNixSpace(value, i)
+	}
+
+	m := eachSystemCallRegex.FindStringIndex(value[i:])
+	if m == nil {
+		return nil, fmt.Errorf("could not find flake-utils.lib.eachDefaultSystem")
+	}
+	bodyStart := i + m[1]
+	bodyEnd, err := scanNixExpr(value, bodyStart, ")")
+	if err != nil {
+		return nil, err
+	}
+	if err := out.parsePerSystem(value[:bodyEnd], bodyStart, style); err != nil {
+		return nil, err
+	}
+
+	i = skipNixSpace(value, bodyEnd+1)
+	if strings.HasPrefix(value[i:], "//") {
+		i = skipNixSpace(value, i+2)
+		if i >= len(value) || value[i] != '{' {
+			out.skip("outputs after //")
+			return out, nil
+		}
+		end, err := scanNixExpr(value, i+1, "}")
+		if err != nil {
+			return nil, err
+		}
+		bindings, _, err := scanNixBindings(value, i+1, end, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bindings {
+			out.keep(&out.topLevel, b.Name, b.Text())
+		}
+		i = skipNixSpace(value, end+1)
+	}
+	if i < len(value) {
+		out.skip(strings.TrimSpace(value[i:]))
+	}
+	return out, nil
+}
+
+// splits flake-parts outputs
+func parsePartsOutputs(value string) (*flakeOutputs, error) {
+	at, args, i, err := parseNixLambdaHead(value, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := &flakeOutputs{at: at, args: args}
+
+	i = skipNixSpace(value, i)
+	m := mkFlakeCallRegex.FindStringIndex(value[i:])
+	if m == nil {
+		return nil, fmt.Errorf("could not find flake-parts.lib.mkFlake")
+	}
+	i += m[1]
+	if i >= len(value) || value[i] != '{' {
+		return nil, fmt.Errorf("expected the arguments of mkFlake")
+	}
+	argsEnd, err := scanNixExpr(value, i+1, "}")
+	if err != nil {
+		return nil, err
+	}
+	i = skipNixSpace(value, argsEnd+1)
+	if i >= len(value) || value[i] != '{' {
+		return nil, fmt.Errorf("expected the module of mkFlake")
+	}
+	end, err := scanNixExpr(value, i+1, "}")
+	if err != nil {
+		return nil, err
+	}
+	if rest := strings.TrimSpace(value[end+1:]); rest != "" {
+		out.skip(rest)
+	}
+
+	module, _, err := scanNixBindings(value, i+1, end, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range module {
+		switch {
+		case b.Name == "systems":
+		case b.Name == "perSystem":
+			_, args, body, err := parseNixLambdaHead(b.src[:b.end-1], b.value)
+			if err != nil {
+				return nil, fmt.Errorf("perSystem: %w", err)
+			}
+			for _, a := range args {
+				if a != "pkgs" && a != "system" && a != "..." {
+					out.skip("perSystem argument " + a)
+				}
+			}
+			if err := out.parsePerSystem(b.src[:b.end-1], body, StyleFlakeUtils); err != nil {
+				return nil, fmt.Errorf("perSystem: %w", err)
+			}
+		case b.Name == "flake":
+			open := skipNixSpace(b.src, b.value)
+			if b.src[open] != '{' {
+				out.skip("flake = " + b.Value())
+				continue
+			}
+			close, err := scanNixExpr(b.src, open+1, "}")
+			if err != nil {
+				return nil, err
+			}
+			bindings, _, err := scanNixBindings(b.src, open+1, close, false)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range bindings {
+				out.keep(&out.topLevel, t.Name, t.Text())
+			}
+		case strings.HasPrefix(b.Name, "flake."):
+			out.keep(&out.topLevel, strings.TrimPrefix(b.Name, "flake."), b.Renamed(strings.TrimPrefix(b.Name, "flake.")))
+		default:
+			out.skip(b.Name)
+		}
+	}
+	return out, nil
+}
+
+// splits the `let ... in { ... }` of one system starting at i, converting it for style
+func (out *flakeOutputs) parsePerSystem(s string, i int, style string) error {
+	i = skipNixSpace(s, i)
+	if hasNixKeyword(s, i, "let") {
+		bindings, j, err := scanNixBindings(s, i+3, len(s), true)
+		if err != nil {
+			return err
+		}
+		for _, b := range bindings {
+			if b.Name != "pkgs" {
+				out.keep(&out.bindings, b.Name, b.Text())
+			}
+		}
+		i = skipNixSpace(s, j)
+	}
+	if i >= len(s) || s[i] != '{' {
+		out.skip("per-system outputs that are not an attrset")
+		return nil
+	}
+	end, err := scanNixExpr(s, i+1, "}")
+	if err != nil {
+		return err
+	}
+	if rest := strings.TrimSpace(s[end+1:]); rest != "" {
+		out.skip(rest)
+	}
+
+	attrs, _, err := scanNixBindings(s, i+1, end, false)
+	if err != nil {
+		return err
+	}
+	for _, b := range attrs {
+		name := b.Name
+		switch {
+		case name == "_module.args.pkgs":
+			// rebuilt by applyPkgsImport
+			continue
+		case style == StyleFlakeParts && name == "defaultPackage":
+			out.keep(&out.perSystem, "packages.default", b.Renamed("packages.default"))
+			continue
+		case style == StyleFlakeUtils && name == "packages.default":
+			out.keep(&out.perSystem, "defaultPackage", b.Renamed("defaultPackage"))
+			continue
+		case name == "" && style == StyleFlakeParts:
+			out.skip(strings.TrimSpace(b.src[b.name:b.end]))
+			continue
+		case strings.HasPrefix(name, "_module"):
+			out.skip(name)
+			continue
+		}
+		if style == StyleFlakeParts && name != "" && !partsPerSystemOptions[strings.SplitN(name, ".", 2)[0]] {
+			out.skip(name)
+			continue
+		}
+		out.keep(&out.perSystem, name, b.Text())
+	}
+	return nil
+}
+
+// carries a binding over verbatim
+func (out *flakeOutputs) keep(list *[]string, name, text string) {
+	*list = append(*list, text)
+	if !managedOutputs[name] {
+		out.uses += text
+	}
+}
+
+// records something Convert can't carry over
+func (out *flakeOutputs) skip(what string) {
+	out.skipped = append(out.skipped, what)
+}
+
+// renders the outputs as flake-utils.lib.eachDefaultSystem, systems are set afterwards by withSystems
+func (out *flakeOutputs) renderUtils() string {
+	args := []string{"self", "nixpkgs"}
+	for _, a := range out.args {
+		if a != "self" && a != "nixpkgs" && a != "flake-utils" && a != "flake-parts" && a != "..." {
+			args = append(args, a)
+		}
+	}
+	args = append(args, "flake-utils", "...")
+
+	head := "{ " + strings.Join(args, ", ") + " }:"
+	// keep the @ binding only while the carried over outputs use it
+	if out.at != "" && regexp.MustCompile(`\b`+regexp.QuoteMeta(out.at)+`\b`).MatchString(out.uses) {
+		head = out.at + "@" + head
+	}
+
+	lines := []string{
+		head,
+		"    flake-utils.lib.eachDefaultSystem (system:",
+		"      let",
+		"        pkgs = import nixpkgs { inherit system; };",
+	}
+	lines = append(lines, indentLines(bindingLines(out.bindings), "        ")...)
+	lines = append(lines, "      in {")
+	lines = append(lines, indentLines(bindingLines(out.perSystem), "        ")...)
+	lines = append(lines, "      }")
+	if len(out.topLevel) == 0 {
+		lines = append(lines, "    )")
+	} else {
+		lines = append(lines, "    ) // {")
+		lines = append(lines, indentLines(bindingLines(out.topLevel), "      ")...)
+		lines = append(lines, "    }")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renders the outputs as flake-parts.lib.mkFlake
+func (out *flakeOutputs) renderParts(systems []string) string {
+	var args []string
+	for _, a := range out.args {
+		if a != "flake-utils" && a != "flake-parts" && a != "..." {
+			args = append(args, a)
+		}
+	}
+	args = append(args, "flake-parts", "...")
+
+	lines := []string{
+		"inputs@{ " + strings.Join(args, ", ") + " }:",
+		"    flake-parts.lib.mkFlake { inherit inputs; } {",
+		"      systems = " + formatSystemsList(systems) + ";",
+		"",
+	}
+	if len(out.topLevel) > 0 {
+		lines = append(lines, "      flake = {")
+		lines = append(lines, indentLines(bindingLines(out.topLevel), "        ")...)
+		lines = append(lines, "      };", "")
+	}
+	if len(out.bindings) == 0 {
+		lines = append(lines, "      perSystem = { pkgs, system, ... }: {")
+		lines = append(lines, indentLines(bindingLines(out.perSystem), "        ")...)
+		lines = append(lines, "      };")
+	} else {
+		lines = append(lines, "      perSystem = { pkgs, system, ... }:", "        let")
+		lines = append(lines, indentLines(bindingLines(out.bindings), "          ")...)
+		lines = append(lines, "        in {")
+		lines = append(lines, indentLines(bindingLines(out.perSystem), "          ")...)
+		lines = append(lines, "        };")
+	}
+	lines = append(lines, "    }")
+	return strings.Join(lines, "\n")
+}
+
+// dedents verbatim binding texts into lines, keeping the comments and blank lines between them
+func bindingLines(texts []string) []string {
+	lines := strings.Split(strings.Join(texts, ""), "\n")
+	// the first line continues the one that opened the block
+	first := strings.TrimSpace(lines[0])
+	lines = lines[1:]
+
+	common := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if n := len(getLineIndentation(l)); common == -1 || n < common {
+			common = n
+		}
+	}
+	for i, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = l[common:]
+		}
+	}
+	if first != "" {
+		lines = append([]string{first}, lines...)
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+// returns the dedented lines between the braces opened by marker
+func blockBody(flake, marker string) ([]string, bool) {
+	idx := strings.Index(flake, marker)
+	if idx == -1 {
+		return nil, false
+	}
+	openIdx := idx + strings.LastIndex(marker, "{") + 1
+	endIdx := findClosingBrace(flake, openIdx)
+	if endIdx >= len(flake) {
+		return nil, false
+	}
+
+	body := flake[openIdx:endIdx]
+	// drop the rest of the opening line and the indentation of the closing one
+	if nl := strings.Index(body, "\n"); nl != -1 {
+		body = body[nl+1:]
+	}
+	if nl := strings.LastIndex(body, "\n"); nl != -1 {
+		body = body[:nl]
+	}
+
+	lines := strings.Split(body, "\n")
+	common := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		ind := getLineIndentation(line)
+		if first || len(ind) < len(common) {
+			common = ind
+			first = false
+		}
+	}
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = line[len(common):]
+	}
+	return lines, true
+}
+
+// prefixes every non-empty line with indent
+func indentLines(lines []string, indent string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if l != "" {
+			out[i] = indent + l
+		}
+	}
+	return out
+}
+
+// reports whether two system lists match, ignoring order
+func sameSystems(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			return false
+		}
+	}
+	return true
+}
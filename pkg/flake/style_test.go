@@ -0,0 +1,133 @@
+package flake
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// creates a flake like flk flake init in a temporary directory
+func newTestFlake(t *testing.T, style string) *Flake {
+	t.Helper()
+	f, err := New(filepath.Join(t.TempDir(), "flake.nix"), style)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.GenerateFlk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.GenerateInputs(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	if err := f.AddPackage("jq", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.EnableImage(); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := f.PackageYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.Pname = "hello"
+	if err := f.writePackageYAML(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.InitModules(false, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"pkgs.jq", "packages.image = pkgs.dockerTools.buildLayeredImage", "nixosModules.default = import ./.flk/modules/nixos.nix", "homeManagerModules.default = import ./.flk/modules/home-manager.nix"}
+	for _, style := range []string{StyleFlakeParts, StyleFlakeUtils} {
+		if err := f.Convert(style); err != nil {
+			t.Fatalf("convert to %s: %v", style, err)
+		}
+		if got := f.Style(); got != style {
+			t.Fatalf("style after converting to %s is %s", style, got)
+		}
+		for _, w := range want {
+			if !strings.Contains(f.String(), w) {
+				t.Errorf("after converting to %s the flake lacks %q:\n%s", style, w, f.String())
+			}
+		}
+		if err := checkNixSyntax(f.String()); err != nil {
+			t.Errorf("after converting to %s: %v", style, err)
+		}
+	}
+}
+
+// rewrites the saved flake.nix with edit and loads it again
+func editTestFlake(t *testing.T, f *Flake, edit func(string) string) *Flake {
+	t.Helper()
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.Path, []byte(edit(f.String())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestConvertKeepsOutputs(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	if err := f.AddPackage("jq", ""); err != nil {
+		t.Fatal(err)
+	}
+	f = editTestFlake(t, f, func(s string) string {
+		s = strings.Replace(s, "{\n", "{\n  description = \"demo\";\n", 1)
+		s = strings.Replace(s, "        pkgs = import nixpkgs { inherit system; };\n",
+			"        pkgs = import nixpkgs { inherit system; };\n        helper = pkgs.writeShellScriptBin \"helper\" \"echo hi\";\n", 1)
+		return strings.Replace(s, "      in {\n", "      in {\n"+
+			"        devShells.ci = pkgs.mkShell { packages = [ helper ]; };\n"+
+			"        apps.default = { type = \"app\"; program = \"${helper}/bin/helper\"; };\n"+
+			"        packages.helper = helper;\n\n", 1)
+	})
+
+	want := []string{
+		`description = "demo";`,
+		`helper = pkgs.writeShellScriptBin "helper" "echo hi";`,
+		"devShells.ci = pkgs.mkShell { packages = [ helper ]; };",
+		`apps.default = { type = "app"; program = "${helper}/bin/helper"; };`,
+		"packages.helper = helper;",
+		"pkgs.jq",
+	}
+	for _, style := range []string{StyleFlakeParts, StyleFlakeUtils} {
+		if err := f.Convert(style); err != nil {
+			t.Fatalf("convert to %s: %v", style, err)
+		}
+		for _, w := range want {
+			if !strings.Contains(f.String(), w) {
+				t.Errorf("after converting to %s the flake lacks %q:\n%s", style, w, f.String())
+			}
+		}
+		if err := checkNixSyntax(f.String()); err != nil {
+			t.Errorf("after converting to %s: %v", style, err)
+		}
+	}
+	if !strings.Contains(f.String(), "outputs = { self, nixpkgs, flake-utils, ... }:") {
+		t.Errorf("outputs arguments changed on the round trip:\n%s", f.String())
+	}
+
+	// flake-parts has no option for a per-system hydraJobs
+	f = editTestFlake(t, f, func(s string) string {
+		return strings.Replace(s, "      in {\n", "      in {\n        hydraJobs.shell = self.devShells.${system}.default;\n", 1)
+	})
+	before := f.String()
+	err := f.Convert(StyleFlakeParts)
+	if KindOf(err) != KindConflict || !strings.Contains(err.Error(), "hydraJobs.shell") {
+		t.Errorf("converting a per-system hydraJobs: got %v, want a conflict naming it", err)
+	}
+	if f.String() != before {
+		t.Error("a failed conversion changed the flake")
+	}
+}
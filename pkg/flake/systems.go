@@ -0,0 +1,135 @@
+package flake
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// systems flake-utils.lib.eachDefaultSystem expands to
+var flakeUtilsDefaultSystems = []string{
+	"x86_64-linux",
+	"aarch64-linux",
+	"x86_64-darwin",
+	"aarch64-darwin",
+}
+
+var systemNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+-[a-zA-Z0-9_-]+$`)
+
+// matches the systems list of the genAttrs helper
+var systemsLineRegex = regexp.MustCompile(`^(\s*)systems\s*=\s*\[(.*)\];\s*$`)
+
+// Systems returns the systems the flake outputs are generated for
+func (f *Flake) Systems() ([]string, error) {
+	for _, line := range strings.Split(f.content, "\n") {
+		if m := systemsLineRegex.FindStringSubmatch(line); m != nil {
+			return parseSystemsList(m[2]), nil
+		}
+	}
+
+	if strings.Contains(f.content, "flake-utils.lib.eachDefaultSystem") {
+		return append([]string{}, flakeUtilsDefaultSystems...), nil
+	}
+
+	return nil, newError(KindParse, "could not find a systems helper in %s", f.Path)
+}
+
+// parses `"x86_64-linux" "aarch64-linux"` into a list
+func parseSystemsList(list string) []string {
+	var systems []string
+	for _, field := range strings.Fields(list) {
+		field = strings.Trim(field, `"`)
+		if field != "" {
+			systems = append(systems, field)
+		}
+	}
+	return systems
+}
+
+// renders a list of systems as a nix list body
+func formatSystemsList(systems []string) string {
+	quoted := make([]string, len(systems))
+	for i, s := range systems {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return "[ " + strings.Join(quoted, " ") + " ]"
+}
+
+// checks that every system looks like a nix system double
+func validateSystems(systems []string) error {
+	if len(systems) == 0 {
+		return fmt.Errorf("at least one system is required")
+	}
+	for _, s := range systems {
+		if !systemNameRegex.MatchString(s) {
+			return fmt.Errorf("invalid system %q, expected something like x86_64-linux", s)
+		}
+	}
+	return nil
+}
+
+// SetSystems sets the systems of a flake, replacing flake-utils with a genAttrs helper if needed
+func (f *Flake) SetSystems(systems []string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	if err := validateSystems(systems); err != nil {
+		return err
+	}
+
+	flake, err := withSystems(f.content, systems)
+	if err != nil {
+		return fmt.Errorf("could not set systems in %s: %w", f.Path, err)
+	}
+	f.content = flake
+	return nil
+}
+
+// rewrites the systems list, or swaps eachDefaultSystem for the forAllSystems helper
+func withSystems(flake string, systems []string) (string, error) {
+	lines := strings.Split(flake, "\n")
+
+	// helper already present, only update the list
+	for i, line := range lines {
+		if m := systemsLineRegex.FindStringSubmatch(line); m != nil {
+			lines[i] = m[1] + "systems = " + formatSystemsList(systems) + ";"
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+
+	eachIdx := -1
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+		if strings.HasPrefix(trim, "flake-utils.lib.eachDefaultSystem") || strings.HasPrefix(trim, "flake-utils.lib.eachSystem") {
+			eachIdx = i
+			break
+		}
+	}
+	if eachIdx == -1 {
+		return "", newError(KindParse, "could not find flake-utils.lib.eachDefaultSystem")
+	}
+
+	indent := getLineIndentation(lines[eachIdx])
+	helper := []string{
+		indent + "let",
+		indent + "  systems = " + formatSystemsList(systems) + ";",
+		indent + "  forAllSystems = nixpkgs.lib.genAttrs systems;",
+		indent + "  eachSystem = f: builtins.zipAttrsWith (_: builtins.foldl' (a: b: a // b) { }) (builtins.attrValues (forAllSystems (system: builtins.mapAttrs (_: value: { ${system} = value; }) (f system))));",
+		indent + "in",
+		indent + "eachSystem (system:",
+	}
+
+	newLines := append([]string{}, lines[:eachIdx]...)
+	newLines = append(newLines, helper...)
+	newLines = append(newLines, lines[eachIdx+1:]...)
+
+	// flake-utils is no longer an argument of outputs
+	for i, line := range newLines {
+		if strings.Contains(line, "outputs =") {
+			newLines[i] = strings.Replace(line, " flake-utils,", "", 1)
+			break
+		}
+	}
+
+	return strings.Join(newLines, "\n"), nil
+}
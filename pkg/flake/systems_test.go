@@ -0,0 +1,40 @@
+package flake
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetSystems(t *testing.T) {
+	f := newTestFlake(t, StyleFlakeUtils)
+	if err := f.AddPackage("jq", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, systems := range [][]string{{"x86_64-linux", "aarch64-linux"}, {"aarch64-linux"}} {
+		if err := f.SetSystems(systems); err != nil {
+			t.Fatalf("set systems %q: %v", systems, err)
+		}
+		got, err := f.Systems()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, systems) {
+			t.Errorf("systems after setting %q are %q", systems, got)
+		}
+		if strings.Contains(f.String(), "eachDefaultSystem") || strings.Count(f.String(), "forAllSystems =") != 1 {
+			t.Errorf("after setting %q the flake does not have one forAllSystems helper:\n%s", systems, f.String())
+		}
+		if !strings.Contains(f.String(), "pkgs.jq") {
+			t.Errorf("after setting %q the flake lost its packages:\n%s", systems, f.String())
+		}
+		if err := checkNixSyntax(f.String()); err != nil {
+			t.Errorf("after setting %q: %v", systems, err)
+		}
+	}
+
+	if err := f.SetSystems([]string{"linux"}); err == nil {
+		t.Error("set systems accepted linux")
+	}
+}
@@ -0,0 +1,280 @@
+package flake
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed all:templates
+var builtinTemplates embed.FS
+
+// file of a template holding its description, it is not rendered
+const templateConfigFile = "template.yml"
+
+var templateNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+
+// Template is a flake.nix and .flk skeleton a flake can be created from
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Dir is the directory of a user template, empty for built-in ones
+	Dir string `json:"dir,omitempty"`
+
+	fsys fs.FS
+}
+
+// TemplateVars are the values templates are rendered with
+type TemplateVars struct {
+	Pname       string
+	Description string
+	Version     string
+	Systems     []string
+	NixpkgsURL  string
+}
+
+// structure of template.yml
+type templateYAML struct {
+	Description string `yaml:"description"`
+}
+
+// functions available to templates
+var templateFuncs = template.FuncMap{
+	"nixString": nixString,
+	"nixList":   nixStringList,
+}
+
+// UserTemplatesDir returns the directory of user templates, ~/.config/flk/templates
+func UserTemplatesDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "flk", "templates"), nil
+}
+
+// loads the template rooted at fsys
+func loadTemplate(name, dir string, fsys fs.FS) (Template, error) {
+	t := Template{Name: name, Dir: dir, fsys: fsys}
+	data, err := fs.ReadFile(fsys, templateConfigFile)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return t, fmt.Errorf("could not read %s of template %s: %w", templateConfigFile, name, err)
+	}
+	var cfg templateYAML
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return t, newError(KindParse, "could not unmarshal %s of template %s: %w", templateConfigFile, name, err)
+	}
+	t.Description = cfg.Description
+	return t, nil
+}
+
+// Templates returns the built-in and user templates sorted by name, user templates replace built-in ones of the same name
+func Templates() ([]Template, error) {
+	byName := map[string]Template{}
+
+	entries, err := fs.ReadDir(builtinTemplates, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("could not read built-in templates: %w", err)
+	}
+	for _, e := range entries {
+		sub, err := fs.Sub(builtinTemplates, path.Join("templates", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := loadTemplate(e.Name(), "", sub)
+		if err != nil {
+			return nil, err
+		}
+		byName[t.Name] = t
+	}
+
+	userDir, err := UserTemplatesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err = os.ReadDir(userDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read %s: %w", userDir, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(userDir, e.Name())
+		t, err := loadTemplate(e.Name(), dir, os.DirFS(dir))
+		if err != nil {
+			return nil, err
+		}
+		byName[t.Name] = t
+	}
+
+	templates := make([]Template, 0, len(byName))
+	for _, t := range byName {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// FindTemplate returns the template called name
+func FindTemplate(name string) (Template, error) {
+	templates, err := Templates()
+	if err != nil {
+		return Template{}, err
+	}
+	for _, t := range templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return Template{}, newError(KindNotFound, "template not found: %s", name)
+}
+
+// Files returns the files of the template as written, keyed by slash separated path
+func (t Template) Files() (map[string]string, error) {
+	files := map[string]string{}
+	err := fs.WalkDir(t.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || p == templateConfigFile {
+			return nil
+		}
+		data, err := fs.ReadFile(t.fsys, p)
+		if err != nil {
+			return err
+		}
+		files[p] = string(data)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read template %s: %w", t.Name, err)
+	}
+	if _, ok := files["flake.nix"]; !ok {
+		return nil, newError(KindParse, "template %s has no flake.nix", t.Name)
+	}
+	return files, nil
+}
+
+// Render returns the files of the template rendered with vars
+func (t Template) Render(vars TemplateVars) (map[string]string, error) {
+	if len(vars.Systems) == 0 {
+		vars.Systems = flakeUtilsDefaultSystems
+	}
+	if vars.Version == "" {
+		vars.Version = "0.1"
+	}
+	if vars.NixpkgsURL == "" {
+		vars.NixpkgsURL = defaultNixpkgsURL
+	}
+	files, err := t.Files()
+	if err != nil {
+		return nil, err
+	}
+	for p, content := range files {
+		tmpl, err := template.New(p).Funcs(templateFuncs).Option("missingkey=error").Parse(content)
+		if err != nil {
+			return nil, newError(KindParse, "could not parse %s of template %s: %w", p, t.Name, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, vars); err != nil {
+			return nil, newError(KindParse, "could not render %s of template %s: %w", p, t.Name, err)
+		}
+		files[p] = buf.String()
+	}
+	return files, nil
+}
+
+// NewFromTemplate returns a flake rendered from t. The .flk folder is generated from it and
+// overwritten by the .flk files of the template at once, flake.nix is written on Save.
+func NewFromTemplate(flakePath string, t Template, vars TemplateVars) (*Flake, error) {
+	files, err := t.Render(vars)
+	if err != nil {
+		return nil, err
+	}
+	f := &Flake{Path: flakePath, content: files["flake.nix"]}
+	if err := f.GenerateFlk(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for p := range files {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	for _, p := range names {
+		// only the .flk skeleton, other files of the project are left alone
+		if !strings.HasPrefix(p, ".flk/") {
+			continue
+		}
+		dest := f.resolve(filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, fmt.Errorf("could not create %s: %w", filepath.Dir(dest), err)
+		}
+		if err := os.WriteFile(dest, []byte(files[p]), 0644); err != nil {
+			return nil, fmt.Errorf("could not write %s: %w", dest, err)
+		}
+	}
+
+	if err := f.Apply(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// AddTemplate copies the template in src to the user templates as name
+func AddTemplate(name, src string) (Template, error) {
+	if !templateNameRegex.MatchString(name) {
+		return Template{}, newError(KindConflict, "invalid template name %q", name)
+	}
+	if _, err := os.Stat(filepath.Join(src, "flake.nix")); err != nil {
+		return Template{}, newError(KindNotFound, "%s has no flake.nix: %w", src, err)
+	}
+	userDir, err := UserTemplatesDir()
+	if err != nil {
+		return Template{}, err
+	}
+	dest := filepath.Join(userDir, name)
+	if _, err := os.Stat(dest); err == nil {
+		return Template{}, newError(KindAlreadyExists, "template already exists: %s", dest)
+	}
+
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		// leave the history of a git checkout behind
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dest, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		return Template{}, fmt.Errorf("could not copy template to %s: %w", dest, err)
+	}
+	return loadTemplate(name, dest, os.DirFS(dest))
+}
@@ -0,0 +1,26 @@
+{
+{{- if .Description}}
+  description = {{nixString .Description}};
+{{end}}
+  inputs = {
+    nixpkgs.url = {{nixString .NixpkgsURL}};
+    flake-parts.url = "github:hercules-ci/flake-parts";
+  };
+
+  outputs = inputs@{ flake-parts, ... }:
+    flake-parts.lib.mkFlake { inherit inputs; } {
+      systems = {{nixList .Systems}};
+
+      perSystem = { pkgs, system, ... }: {
+        devShells.default = pkgs.mkShell {
+          packages = [
+
+          ];
+
+          shellHook = ''
+            echo "Development environment loaded"
+          '';
+        };
+      };
+    };
+}
@@ -0,0 +1,3 @@
+export GOCACHE=$TMPDIR/go-cache
+export GOPATH=$TMPDIR/go
+go build -o {{.Pname}} .
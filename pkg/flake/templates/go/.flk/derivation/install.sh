@@ -0,0 +1,2 @@
+mkdir -p $out/bin
+cp {{.Pname}} $out/bin/
@@ -0,0 +1,29 @@
+{
+{{- if .Description}}
+  description = {{nixString .Description}};
+{{end}}
+  inputs = {
+    nixpkgs.url = {{nixString .NixpkgsURL}};
+    flake-utils.url = "github:numtide/flake-utils";
+  };
+
+  outputs = { self, nixpkgs, flake-utils, ... }:
+    flake-utils.lib.eachDefaultSystem (system:
+      let
+        pkgs = import nixpkgs { inherit system; };
+      in {
+        devShells = {
+          default = pkgs.mkShell {
+            packages = [
+              pkgs.go
+              pkgs.gopls
+            ];
+
+            shellHook = ''
+              echo "Development environment loaded"
+            '';
+          };
+        };
+      }
+    );
+}
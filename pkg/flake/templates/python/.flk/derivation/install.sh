@@ -0,0 +1,4 @@
+mkdir -p $out/bin
+cp main.py $out/bin/{{.Pname}}
+chmod +x $out/bin/{{.Pname}}
+patchShebangs $out/bin
@@ -0,0 +1,2 @@
+export CARGO_HOME=$TMPDIR/cargo
+cargo build --release --offline
@@ -0,0 +1,2 @@
+mkdir -p $out/bin
+cp target/release/{{.Pname}} $out/bin/
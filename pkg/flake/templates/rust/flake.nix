@@ -0,0 +1,32 @@
+{
+{{- if .Description}}
+  description = {{nixString .Description}};
+{{end}}
+  inputs = {
+    nixpkgs.url = {{nixString .NixpkgsURL}};
+    flake-utils.url = "github:numtide/flake-utils";
+  };
+
+  outputs = { self, nixpkgs, flake-utils, ... }:
+    flake-utils.lib.eachDefaultSystem (system:
+      let
+        pkgs = import nixpkgs { inherit system; };
+      in {
+        devShells = {
+          default = pkgs.mkShell {
+            packages = [
+              pkgs.cargo
+              pkgs.rustc
+              pkgs.rust-analyzer
+              pkgs.clippy
+              pkgs.rustfmt
+            ];
+
+            shellHook = ''
+              echo "Development environment loaded"
+            '';
+          };
+        };
+      }
+    );
+}
@@ -0,0 +1,317 @@
+package flake
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ImportSources are the tool managers flk can import from, and the file each one reads by default
+var ImportSources = map[string]string{
+	"devbox": "devbox.json",
+	"asdf":   ".tool-versions",
+	"mise":   "mise.toml",
+	"devenv": "devenv.nix",
+}
+
+// maps a tool name to its nixpkgs attribute, Versioned is formatted with the major and minor version
+type toolMapping struct {
+	Attr      string
+	Versioned string
+}
+
+// tool names used by devbox, asdf, mise and devenv
+var toolMappings = map[string]toolMapping{
+	"nodejs":     {"nodejs", "nodejs_%[1]s"},
+	"node":       {"nodejs", "nodejs_%[1]s"},
+	"python":     {"python3", "python%[1]s%[2]s"},
+	"golang":     {"go", "go_%[1]s_%[2]s"},
+	"go":         {"go", "go_%[1]s_%[2]s"},
+	"ruby":       {"ruby", "ruby_%[1]s_%[2]s"},
+	"java":       {"jdk", "jdk%[1]s"},
+	"jdk":        {"jdk", "jdk%[1]s"},
+	"php":        {"php", "php%[1]s%[2]s"},
+	"erlang":     {"erlang", "erlang_%[1]s"},
+	"elixir":     {"elixir", "elixir_%[1]s_%[2]s"},
+	"postgres":   {"postgresql", "postgresql_%[1]s"},
+	"postgresql": {"postgresql", "postgresql_%[1]s"},
+	"rust":       {"rustc", ""},
+	"helm":       {"kubernetes-helm", ""},
+	"awscli":     {"awscli2", ""},
+	"aws-cli":    {"awscli2", ""},
+	"gcloud":     {"google-cloud-sdk", ""},
+	"github-cli": {"gh", ""},
+	"poetry":     {"poetry", ""},
+	"yarn":       {"yarn", ""},
+	"pnpm":       {"pnpm", ""},
+	"bun":        {"bun", ""},
+	"deno":       {"deno", ""},
+	"terraform":  {"terraform", ""},
+	"kubectl":    {"kubectl", ""},
+	"zig":        {"zig", ""},
+}
+
+var (
+	versionRegex  = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?`)
+	tomlLineRegex = regexp.MustCompile(`^\s*("?[^"=]+"?)\s*=\s*(.+?)\s*$`)
+)
+
+// resolves a tool and version to a nixpkgs attribute, warning when the version can't be pinned
+func (l *Import) resolveTool(name, version string) string {
+	name = strings.TrimSpace(name)
+	version = strings.TrimSpace(version)
+
+	m, known := toolMappings[name]
+	if !known {
+		m = toolMapping{Attr: name}
+	}
+
+	if version == "" || version == "latest" {
+		return m.Attr
+	}
+	v := versionRegex.FindStringSubmatch(version)
+	if v == nil || m.Versioned == "" {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("%s@%s is not pinned, using the nixpkgs version of %s", name, version, m.Attr))
+		return m.Attr
+	}
+	if strings.Contains(m.Versioned, "%[2]s") && v[2] == "" {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("%s@%s needs a minor version to be pinned, using %s", name, version, m.Attr))
+		return m.Attr
+	}
+	attr := fmt.Sprintf(m.Versioned, v[1], v[2])
+	return attr
+}
+
+// adds a tool unless it is already in the list
+func (l *Import) addTool(name, version string) {
+	attr := l.resolveTool(name, version)
+	if !containsString(l.Packages, attr) {
+		l.Packages = append(l.Packages, attr)
+	}
+}
+
+// parses devbox.json
+func parseDevbox(data []byte) (*Import, error) {
+	var cfg struct {
+		Packages json.RawMessage   `json:"packages"`
+		Env      map[string]string `json:"env"`
+		Shell    struct {
+			InitHook json.RawMessage `json:"init_hook"`
+		} `json:"shell"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, newError(KindParse, "could not parse devbox.json: %w", err)
+	}
+
+	l := &Import{}
+
+	// packages are either ["go@1.22"] or {"go": "1.22"} / {"go": {"version": "1.22"}}
+	var list []string
+	var table map[string]json.RawMessage
+	if err := json.Unmarshal(cfg.Packages, &list); err == nil {
+		for _, spec := range list {
+			name, version, _ := strings.Cut(spec, "@")
+			l.addTool(name, version)
+		}
+	} else if err := json.Unmarshal(cfg.Packages, &table); err == nil {
+		for _, name := range sortedKeys(table) {
+			var version string
+			var obj struct {
+				Version string `json:"version"`
+			}
+			if json.Unmarshal(table[name], &version) != nil && json.Unmarshal(table[name], &obj) == nil {
+				version = obj.Version
+			}
+			l.addTool(name, version)
+		}
+	} else if len(cfg.Packages) > 0 {
+		return nil, newError(KindParse, "could not parse devbox.json packages")
+	}
+
+	for _, k := range sortedKeys(cfg.Env) {
+		l.Env = append(l.Env, nixAttr{Name: k, Value: nixString(cfg.Env[k])})
+	}
+
+	// init_hook is a string or a list of lines
+	var hook string
+	var hookLines []string
+	if err := json.Unmarshal(cfg.Shell.InitHook, &hookLines); err == nil {
+		hook = strings.Join(hookLines, "\n")
+	} else if err := json.Unmarshal(cfg.Shell.InitHook, &hook); err != nil && len(cfg.Shell.InitHook) > 0 {
+		l.Warnings = append(l.Warnings, "could not translate shell.init_hook")
+	}
+	if hook != "" {
+		l.ShellHook = escapeForIndentedString(hook)
+	}
+	return l, nil
+}
+
+// parses an asdf .tool-versions file
+func parseToolVersions(data []byte) (*Import, error) {
+	l := &Import{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		version := ""
+		if len(fields) > 1 {
+			// the first version is the one asdf uses
+			version = fields[1]
+		}
+		l.addTool(fields[0], version)
+	}
+	return l, nil
+}
+
+// parses the [tools] and [env] tables of mise.toml
+func parseMiseToml(data []byte) (*Import, error) {
+	l := &Import{}
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		trim := strings.TrimSpace(line)
+		if trim == "" || strings.HasPrefix(trim, "#") {
+			continue
+		}
+		if strings.HasPrefix(trim, "[") {
+			section = strings.Trim(trim, "[] ")
+			continue
+		}
+		m := tomlLineRegex.FindStringSubmatch(trim)
+		if m == nil {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate %q", trim))
+			continue
+		}
+		key := strings.Trim(m[1], `" `)
+		value := m[2]
+
+		switch section {
+		case "tools":
+			// "20", ["20", "18"] or { version = "20" }
+			version := ""
+			if v := quotedRegex.FindStringSubmatch(value); v != nil {
+				version = v[1]
+			}
+			// backends such as npm:prettier are not nixpkgs tools
+			if strings.Contains(key, ":") {
+				l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate tool %s", key))
+				continue
+			}
+			l.addTool(key, version)
+		case "env":
+			if s, ok := parseNixString(value); ok {
+				l.Env = append(l.Env, nixAttr{Name: key, Value: nixString(s)})
+			} else {
+				l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate env %s", key))
+			}
+		default:
+			l.Warnings = append(l.Warnings, fmt.Sprintf("skipped [%s] %s", section, key))
+		}
+	}
+	return l, nil
+}
+
+// parses the module in devenv.nix
+func parseDevenv(data []byte) (*Import, error) {
+	src := string(data)
+	argsEnd := strings.Index(src, "}:")
+	if argsEnd == -1 {
+		return nil, newError(KindParse, "could not find the module arguments in devenv.nix")
+	}
+	i := skipNixSpace(src, argsEnd+2)
+	if i >= len(src) || src[i] != '{' {
+		return nil, newError(KindParse, "could not find the module body in devenv.nix")
+	}
+	end, err := scanNixExpr(src, i+1, "}")
+	if err != nil {
+		return nil, newError(KindParse, "could not parse devenv.nix: %w", err)
+	}
+	attrs, warnings, err := parseNixAttrs(src[i+1 : end])
+	if err != nil {
+		return nil, newError(KindParse, "could not parse devenv.nix: %w", err)
+	}
+
+	l := &Import{Warnings: warnings}
+	withPkgs := strings.Contains(src, "with pkgs;")
+	for _, a := range attrs {
+		switch {
+		case a.Name == "packages":
+			l.translatePackages(a.Value, withPkgs, &l.Packages)
+		case a.Name == "enterShell":
+			hook, ok := parseNixScript(a.Value)
+			if !ok {
+				l.Warnings = append(l.Warnings, "could not translate enterShell, it is not a plain string")
+				continue
+			}
+			l.ShellHook = hook
+		case strings.HasPrefix(a.Name, "env.") && isPlainNixValue(a.Value):
+			l.Env = append(l.Env, nixAttr{Name: strings.TrimPrefix(a.Name, "env."), Value: a.Value})
+		case strings.HasPrefix(a.Name, "languages.") && strings.HasSuffix(a.Name, ".enable") && a.Value == "true":
+			l.addTool(strings.TrimSuffix(strings.TrimPrefix(a.Name, "languages."), ".enable"), "")
+		default:
+			l.Warnings = append(l.Warnings, fmt.Sprintf("could not translate devenv option %s", a.Name))
+		}
+	}
+	return l, nil
+}
+
+// reads the config of a tool manager
+func parseToolImport(from, path string) (*Import, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	switch from {
+	case "devbox":
+		return parseDevbox(data)
+	case "asdf":
+		return parseToolVersions(data)
+	case "mise":
+		return parseMiseToml(data)
+	case "devenv":
+		l, err := parseDevenv(data)
+		if err != nil {
+			return nil, err
+		}
+		// only devenv.nix is imported, the inputs in devenv.yaml have to be carried over by hand
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), "devenv.yaml")); err == nil {
+			l.Warnings = append(l.Warnings, "devenv.yaml is not imported, add its inputs to flake.nix by hand")
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("unknown import source %q, expected devbox, asdf, mise or devenv", from)
+}
+
+// ImportTool adds the packages and init hook of a tool manager's config at path to the flake at target, created
+// with opts when missing
+func ImportTool(from, path, target string, opts Options) (*Import, error) {
+	l, err := parseToolImport(from, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeImport(l, target, opts); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// escapes plain shell for use inside an indented string
+func escapeForIndentedString(s string) string {
+	return strings.NewReplacer("''", "'''", "${", "''${").Replace(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
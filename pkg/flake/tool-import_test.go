@@ -0,0 +1,83 @@
+package flake
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportTool(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"devbox.json":    `{ "packages": [ "nodejs@20.11.1", "go@latest", "ripgrep" ], "shell": { "init_hook": [ "echo devbox" ] } }`,
+		".tool-versions": "python 3.12.1\nterraform 1.7.0\nnodejs lts\n",
+		"mise.toml":      "[tools]\ngolang = \"1.22\"\njava = \"v21\"\n",
+		"devenv.nix":     "{ pkgs, ... }:\n{\n  packages = [ pkgs.git ];\n  languages.go.enable = true;\n  enterShell = ''\n    echo devenv\n  '';\n}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := filepath.Join(dir, "flake.nix")
+	var warnings []string
+	for _, from := range []string{"devbox", "asdf", "mise", "devenv"} {
+		l, err := ImportTool(from, filepath.Join(dir, ImportSources[from]), target, Options{})
+		if err != nil {
+			t.Fatalf("import from %s: %v", from, err)
+		}
+		warnings = append(warnings, l.Warnings...)
+	}
+
+	f, err := Load(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages, err := f.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range packages {
+		names = append(names, strings.TrimPrefix(p.Name, "pkgs."))
+	}
+	// versions are pinned when nixpkgs has an attribute for them, go is in both devbox and devenv
+	want := []string{"nodejs_20", "go", "ripgrep", "python312", "terraform", "nodejs", "go_1_22", "jdk21", "git"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("packages are %q, want %q", names, want)
+	}
+	wantWarnings := []string{"terraform@1.7.0", "nodejs@lts", "skipped go"}
+	if len(warnings) != len(wantWarnings) {
+		t.Errorf("warnings are %q, want the unpinned terraform and nodejs and the second go", warnings)
+	}
+	for i := 0; i < len(warnings) && i < len(wantWarnings); i++ {
+		if !strings.Contains(warnings[i], wantWarnings[i]) {
+			t.Errorf("warning %d is %q, want one about %s", i, warnings[i], wantWarnings[i])
+		}
+	}
+	hook, err := os.ReadFile(f.resolve(shellHookPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(hook), "echo devbox") || !strings.Contains(string(hook), "echo devenv") {
+		t.Errorf("the init hooks were not merged:\n%s", hook)
+	}
+	if err := checkNixSyntax(f.String()); err != nil {
+		t.Error(err)
+	}
+
+	// the inputs of devenv.yaml are left out
+	if err := os.WriteFile(filepath.Join(dir, "devenv.yaml"), []byte("inputs:\n  nixpkgs:\n    url: github:cachix/devenv-nixpkgs/rolling\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := ImportTool("devenv", filepath.Join(dir, "devenv.nix"), target, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Warnings) != 3 || !strings.Contains(l.Warnings[0], "devenv.yaml") {
+		t.Errorf("warnings are %q, want one about devenv.yaml and the skipped git and go", l.Warnings)
+	}
+}
@@ -0,0 +1,325 @@
+package flake
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const workspaceConfigPath = ".flk/workspace.yml"
+
+// MemberFile is the file of a workspace member, imported by the flake.nix at the workspace root
+const MemberFile = "flk.nix"
+
+// Boilerplate content for new workspace members, the per-system outputs of one project
+var memberBoilerplateContent = `# workspace member, imported by the flake.nix at the workspace root
+{ pkgs, ... }:
+
+{
+  devShells = {
+    default = pkgs.mkShell {
+      packages = [
+
+      ];
+
+      shellHook = ''
+        echo "Development environment loaded"
+      '';
+    };
+  };
+
+  defaultPackage = pkgs.stdenv.mkDerivation {
+    pname = "default";
+    version = "0.1";
+    src = ./.;
+    buildInputs = [];
+  };
+}
+`
+
+// structure of .flk/workspace.yml, members are directories relative to flake.nix
+type WorkspaceYAML struct {
+	Members []string `yaml:"members"`
+}
+
+// Member is a project of a workspace, exposed as packages.<name> and devShells.<name>
+type Member struct {
+	Name string `json:"name"`
+	// Dir is relative to the workspace root
+	Dir string `json:"dir"`
+}
+
+var (
+	memberNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+	// lines rendered by applyWorkspace
+	memberLineRegex = regexp.MustCompile(`^\s*[^\s=]+ = \(import \./\S+/` + regexp.QuoteMeta(MemberFile) + ` \{ inherit pkgs; \}\)\.`)
+)
+
+// IsMember reports whether f is the flk.nix of a workspace member rather than a flake.nix
+func (f *Flake) IsMember() bool {
+	return filepath.Base(f.Path) == MemberFile
+}
+
+// fails for workspace members, which only hold a devShell and a derivation
+func (f *Flake) requireFlake() error {
+	if f.IsMember() {
+		return newError(KindConflict, "%s is a workspace member, run this on the flake.nix at the workspace root", f.Path)
+	}
+	return nil
+}
+
+// reads .flk/workspace.yml, ok is false when the flake is not a workspace
+func (f *Flake) readWorkspace() (WorkspaceYAML, bool, error) {
+	var ws WorkspaceYAML
+	data, err := os.ReadFile(f.resolve(workspaceConfigPath))
+	if os.IsNotExist(err) {
+		return ws, false, nil
+	} else if err != nil {
+		return ws, false, fmt.Errorf("could not read %s: %w", workspaceConfigPath, err)
+	}
+	if err := yaml.Unmarshal(data, &ws); err != nil {
+		return ws, false, newError(KindParse, "could not unmarshal %s: %w", workspaceConfigPath, err)
+	}
+	return ws, true, nil
+}
+
+func (f *Flake) writeWorkspace(ws WorkspaceYAML) error {
+	data, err := yaml.Marshal(ws)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s: %w", workspaceConfigPath, err)
+	}
+	if err := os.MkdirAll(f.resolve(".flk"), 0755); err != nil {
+		return fmt.Errorf("could not create .flk folder: %w", err)
+	}
+	if err := os.WriteFile(f.resolve(workspaceConfigPath), data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", workspaceConfigPath, err)
+	}
+	return nil
+}
+
+// cleans a member directory, which has to stay inside the workspace
+func memberDir(dir string) (string, error) {
+	clean := filepath.ToSlash(filepath.Clean(dir))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", newError(KindConflict, "member %s is not a directory inside the workspace", dir)
+	}
+	return clean, nil
+}
+
+// Members returns the members listed in .flk/workspace.yml, nil when the flake is not a workspace
+func (f *Flake) Members() ([]Member, error) {
+	ws, _, err := f.readWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	var members []Member
+	names := map[string]string{}
+	for _, d := range ws.Members {
+		dir, err := memberDir(d)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(dir)
+		if !memberNameRegex.MatchString(name) {
+			return nil, newError(KindParse, "member %s in %s is not a valid attribute name", dir, workspaceConfigPath)
+		}
+		if other, ok := names[name]; ok {
+			return nil, newError(KindConflict, "members %s and %s in %s are both named %s", other, dir, workspaceConfigPath, name)
+		}
+		names[name] = dir
+		members = append(members, Member{Name: name, Dir: dir})
+	}
+	return members, nil
+}
+
+// MemberPath returns the flk.nix of a member
+func (f *Flake) MemberPath(m Member) string {
+	return f.resolve(filepath.Join(m.Dir, MemberFile))
+}
+
+// AddMember adds dir to the workspace, creating its flk.nix and .flk folder when missing
+func (f *Flake) AddMember(dir string) (Member, error) {
+	if err := f.requireFlake(); err != nil {
+		return Member{}, err
+	}
+	dir, err := memberDir(dir)
+	if err != nil {
+		return Member{}, err
+	}
+	ws, _, err := f.readWorkspace()
+	if err != nil {
+		return Member{}, err
+	}
+	for _, d := range ws.Members {
+		if filepath.ToSlash(filepath.Clean(d)) == dir {
+			return Member{}, newError(KindAlreadyExists, "%s is already a workspace member", dir)
+		}
+	}
+	ws.Members = append(ws.Members, dir)
+	if err := f.writeWorkspace(ws); err != nil {
+		return Member{}, err
+	}
+	members, err := f.Members()
+	if err != nil {
+		// keep workspace.yml loadable
+		ws.Members = ws.Members[:len(ws.Members)-1]
+		if werr := f.writeWorkspace(ws); werr != nil {
+			return Member{}, werr
+		}
+		return Member{}, err
+	}
+	m := members[len(members)-1]
+
+	path := f.MemberPath(m)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := createMember(path, m.Name); err != nil {
+			return Member{}, err
+		}
+	} else if err != nil {
+		return Member{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return m, f.applyWorkspace()
+}
+
+// writes a new member with its .flk folder, the derivation is named after the member
+func createMember(path, name string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create %s: %w", filepath.Dir(path), err)
+	}
+	m := &Flake{Path: path, content: memberBoilerplateContent}
+	if err := m.GenerateFlk(); err != nil {
+		return err
+	}
+	pkg, err := m.readPackageYAML()
+	if err != nil {
+		return err
+	}
+	pkg.Pname = name
+	if err := m.writePackageYAML(pkg); err != nil {
+		return err
+	}
+	if err := m.Apply(); err != nil {
+		return err
+	}
+	return m.Save()
+}
+
+// RemoveMember drops dir from the workspace, its files are kept
+func (f *Flake) RemoveMember(dir string) error {
+	if err := f.requireFlake(); err != nil {
+		return err
+	}
+	dir, err := memberDir(dir)
+	if err != nil {
+		return err
+	}
+	ws, _, err := f.readWorkspace()
+	if err != nil {
+		return err
+	}
+	var kept []string
+	for _, d := range ws.Members {
+		if filepath.ToSlash(filepath.Clean(d)) != dir {
+			kept = append(kept, d)
+		}
+	}
+	if len(kept) == len(ws.Members) {
+		return newError(KindNotFound, "%s is not a workspace member", dir)
+	}
+	ws.Members = kept
+	if err := f.writeWorkspace(ws); err != nil {
+		return err
+	}
+	return f.applyWorkspace()
+}
+
+// applies the .flk folder of every member to its flk.nix
+func (f *Flake) applyMembers() error {
+	members, err := f.Members()
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		mf, err := Load(f.MemberPath(m))
+		if err != nil {
+			return err
+		}
+		if err := mf.Apply(); err != nil {
+			return err
+		}
+		if err := mf.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renders packages.<member> and devShells.<member> for every member, removing the ones no longer listed
+func (f *Flake) applyWorkspace() error {
+	members, err := f.Members()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(f.content, "\n") {
+		if memberLineRegex.MatchString(line) {
+			// drop the blank line before the rendered packages too
+			if n := len(lines); n > 0 && strings.TrimSpace(lines[n-1]) == "" {
+				lines = lines[:n-1]
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if len(members) > 0 {
+		parts := flakeStyle(f.content) == StyleFlakeParts
+		marker := "devShells = {"
+		if parts {
+			// the devShell may have another name than default
+			for _, line := range lines {
+				if trim := strings.TrimSpace(line); strings.HasPrefix(trim, "devShells.") && strings.HasSuffix(trim, "= pkgs.mkShell {") {
+					marker = trim
+					break
+				}
+			}
+		}
+		start, end := findAttrBlock(lines, marker)
+		if start == -1 {
+			return newError(KindParse, "could not find the devShells block in %s", f.Path)
+		}
+		indent := getLineIndentation(lines[start])
+		unit := indentUnit(f.content)
+
+		var shells, packages []string
+		for _, m := range members {
+			ref := fmt.Sprintf("(import ./%s/%s { inherit pkgs; })", m.Dir, MemberFile)
+			if parts {
+				shells = append(shells, fmt.Sprintf("%sdevShells.%s = %s.devShells.default;", indent, m.Name, ref))
+			} else {
+				shells = append(shells, fmt.Sprintf("%s%s%s = %s.devShells.default;", indent, unit, m.Name, ref))
+			}
+			packages = append(packages, fmt.Sprintf("%spackages.%s = %s.defaultPackage;", indent, m.Name, ref))
+		}
+
+		var block []string
+		if parts {
+			// next to devShells.default
+			block = append(shells, packages...)
+		} else {
+			// the shells go into the devShells block, the packages after it
+			lines = append(lines[:end], append(shells, lines[end:]...)...)
+			end += len(shells)
+			block = append([]string{""}, packages...)
+		}
+		lines = append(lines[:end+1], append(block, lines[end+1:]...)...)
+	}
+
+	f.content = strings.Join(lines, "\n")
+	return nil
+}
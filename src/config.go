@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+
+	"flk/pkg/flake"
+)
+
+// configuration in effect for the command, loaded before it runs
+var config flake.Config
+
+// the directory whose .flk/config.yml applies, that of the flake or the working directory without one
+func configDir(flag string) (string, error) {
+	dir, err := resolveDir(flag)
+	if flake.KindOf(err) == flake.KindNotFound {
+		return os.Getwd()
+	}
+	return dir, err
+}
+
+// loads the layered configuration for the flake given by flag
+func loadConfig(flag string) error {
+	dir, err := configDir(flag)
+	if err != nil {
+		return err
+	}
+	config, err = flake.LoadConfig(dir)
+	return err
+}
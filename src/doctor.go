@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flk/pkg/flake"
+)
+
+// files nix reads from the flake: flake.nix, flake.lock, the flk.nix of members and the .nix files in .flk folders.
+// Paths are relative to the directory of the flake.
+func nixFiles(f *flake.Flake) ([]string, error) {
+	dir := f.Dir()
+	paths := []string{f.Path}
+	if _, err := os.Stat(filepath.Join(dir, "flake.lock")); err == nil {
+		paths = append(paths, filepath.Join(dir, "flake.lock"))
+	}
+	members, err := f.Members()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		paths = append(paths, f.MemberPath(m))
+	}
+
+	var files []string
+	for _, p := range paths {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rel)
+
+		// overlays and modules are imported from .flk
+		root := filepath.Join(filepath.Dir(p), ".flk")
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() || filepath.Ext(path) != ".nix" {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", root, err)
+		}
+	}
+	return files, nil
+}
+
+// checks that git tracks the files nix reads, flakes in a git repository only see tracked files
+func diagnoseGit(f *flake.Flake) flake.Diagnostic {
+	d := flake.Diagnostic{Check: "git"}
+	if !gitWorkTree(f.Dir()) {
+		d.Status = flake.StatusOK
+		d.Message = "not in a git repository, nix reads every file"
+		return d
+	}
+	files, err := nixFiles(f)
+	if err != nil {
+		d.Status = flake.StatusError
+		d.Message = err.Error()
+		return d
+	}
+	untracked, err := gitUntracked(f.Dir(), files)
+	if err != nil {
+		d.Status = flake.StatusError
+		d.Message = err.Error()
+		return d
+	}
+	if len(untracked) > 0 {
+		d.Status = flake.StatusError
+		d.Message = fmt.Sprintf("not tracked by git, so nix ignores them: %s", strings.Join(untracked, ", "))
+		d.Fix = "git add -N " + strings.Join(untracked, " ") + ", or pass --git-add to flk to stage the files it creates"
+		return d
+	}
+	d.Status = flake.StatusOK
+	d.Message = "git tracks every file nix reads"
+	return d
+}
+
+// checks that a derivation with src = ./. gets every source, nix leaves out files git doesn't track
+func diagnoseSources(f *flake.Flake) flake.Diagnostic {
+	d := flake.Diagnostic{Check: "src"}
+	files, err := untrackedSources(f)
+	if err != nil {
+		d.Status = flake.StatusError
+		d.Message = err.Error()
+		return d
+	}
+	if len(files) > 0 {
+		d.Status = flake.StatusWarning
+		d.Message = fmt.Sprintf("src = ./. leaves out files git doesn't track: %s", summarizeFiles(files))
+		d.Fix = "git add -N the sources the build needs, or add the others to .gitignore"
+		return d
+	}
+	d.Status = flake.StatusOK
+	d.Message = "the derivation gets every source"
+	return d
+}
+
+// runs the checks of flk doctor on the flake at filePath
+func runDoctor(filePath string) ([]flake.Diagnostic, error) {
+	f, err := flake.Load(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return append(f.Diagnose(), diagnoseGit(f), diagnoseSources(f)), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"flk/pkg/flake"
+)
+
+// errors returned by cobra itself, e.g. an unknown command or flag, and bad flag values
+const kindUsage = flake.KindUsage
+
+// exit status of each kind, documented in the README and `flk --help`
+var exitCodes = map[flake.Kind]int{
+	flake.KindError:         1,
+	kindUsage:               2,
+	flake.KindNotFound:      3,
+	flake.KindAlreadyExists: 4,
+	flake.KindParse:         5,
+	flake.KindConflict:      6,
+	flake.KindIO:            7,
+}
+
+// help text listing the exit codes
+const exitCodesHelp = `Exit codes:
+  0  success
+  1  any other error
+  2  usage error, e.g. an unknown command or flag
+  3  not-found: a package, overlay, cache or file does not exist
+  4  already-exists: a package, overlay, cache or file is already there
+  5  parse-error: flake.nix or a .flk file could not be parsed
+  6  conflict: the request contradicts itself or the flake
+  7  io-error: a file could not be read or written`
+
+// creates a usage error for arguments cobra can't check itself
+func usageError(format string, a ...interface{}) error {
+	return &flake.Error{Kind: kindUsage, Err: fmt.Errorf(format, a...)}
+}
+
+// exit status for err
+func exitCode(err error) int {
+	return exitCodes[flake.KindOf(err)]
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flk/pkg/flake"
+)
+
+// default output path of each export target
+var exportTargets = map[string]string{
+	"devcontainer": ".devcontainer/devcontainer.json",
+	"dockerfile":   "Dockerfile",
+}
+
+// structure of devcontainer.json
+type devcontainer struct {
+	Name              string                            `json:"name"`
+	Image             string                            `json:"image"`
+	Features          map[string]map[string]interface{} `json:"features"`
+	PostCreateCommand string                            `json:"postCreateCommand"`
+	Customizations    map[string]interface{}            `json:"customizations"`
+}
+
+// devShell packages of f added on system, without the pkgs. prefix
+func systemPackages(f *flake.Flake, system string) ([]string, error) {
+	entries, err := f.Packages()
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []string
+	for _, e := range entries {
+		if !flake.ConditionHolds(e.Condition, system) {
+			continue
+		}
+		pkgs = append(pkgs, strings.TrimPrefix(e.Name, "pkgs."))
+	}
+	return pkgs, nil
+}
+
+// files the devShell is built from, relative to the directory of f: flake.lock, .flk and those of the members
+func devShellFiles(f *flake.Flake) ([]string, error) {
+	paths := []string{"flake.lock", ".flk"}
+	members, err := f.Members()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		paths = append(paths, filepath.Join(m.Dir, flake.MemberFile), filepath.Join(m.Dir, ".flk"))
+	}
+
+	var files []string
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(f.Dir(), p)); err == nil {
+			files = append(files, filepath.ToSlash(p))
+		}
+	}
+	return files, nil
+}
+
+// renders a devcontainer using the nix feature, terminals enter the flake's devShell so the shellHook runs
+func renderDevcontainer(name string, pkgs []string) ([]byte, error) {
+	dc := devcontainer{
+		Name:  name,
+		Image: "mcr.microsoft.com/devcontainers/base:ubuntu",
+		Features: map[string]map[string]interface{}{
+			"ghcr.io/devcontainers/features/nix:1": {
+				"extraNixConfig": "experimental-features = nix-command flakes",
+				"packages":       strings.Join(pkgs, ","),
+			},
+		},
+		PostCreateCommand: "nix develop --command true",
+		Customizations: map[string]interface{}{
+			"vscode": map[string]interface{}{
+				"settings": map[string]interface{}{
+					"terminal.integrated.defaultProfile.linux": "nix develop",
+					"terminal.integrated.profiles.linux": map[string]interface{}{
+						"nix develop": map[string]interface{}{
+							"path": "nix",
+							"args": []string{"develop"},
+						},
+					},
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(dc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// renders a Dockerfile on nixos/nix that enters the flake's devShell, copying files besides flake.nix first
+func renderDockerfile(pkgs, files []string) []byte {
+	var b strings.Builder
+	b.WriteString("# Generated by flk export dockerfile\n")
+	if len(pkgs) > 0 {
+		b.WriteString("# devShell packages: " + strings.Join(pkgs, " ") + "\n")
+	}
+	b.WriteString(`FROM nixos/nix:latest
+
+RUN echo "experimental-features = nix-command flakes" >> /etc/nix/nix.conf
+
+WORKDIR /workspace
+
+# build the devShell first so it is cached between source changes
+COPY flake.nix ./
+`)
+	for _, p := range files {
+		fmt.Fprintf(&b, "COPY %s ./%s\n", p, p)
+	}
+	b.WriteString(`RUN nix develop --command true
+
+COPY . .
+
+ENTRYPOINT ["nix", "develop", "--command"]
+CMD ["bash"]
+`)
+	return []byte(b.String())
+}
+
+// writes the export target for the flake at filePath, with the packages of system
+func exportFlake(filePath, target, out, system string, force bool) (string, error) {
+	defaultOut, ok := exportTargets[target]
+	if !ok {
+		return "", fmt.Errorf("unknown export target %q, expected devcontainer or dockerfile", target)
+	}
+	if out == "" {
+		// next to the flake, which may be in a parent directory
+		out = filepath.Join(filepath.Dir(filePath), defaultOut)
+	}
+	if _, err := os.Stat(out); err == nil && !force {
+		return "", &flake.Error{Kind: flake.KindAlreadyExists, Err: fmt.Errorf("%s already exists, use --force to overwrite it", out)}
+	}
+
+	if !strings.HasSuffix(system, "-linux") {
+		return "", usageError("containers run linux, %q is not a linux system", system)
+	}
+	f, err := flake.Load(filePath)
+	if err != nil {
+		return "", err
+	}
+	pkgs, err := systemPackages(f, system)
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	switch target {
+	case "devcontainer":
+		absPath, err := filepath.Abs(filePath)
+		if err != nil {
+			return "", err
+		}
+		data, err = renderDevcontainer(filepath.Base(filepath.Dir(absPath)), pkgs)
+		if err != nil {
+			return "", fmt.Errorf("could not render devcontainer.json: %w", err)
+		}
+	case "dockerfile":
+		files, err := devShellFiles(f)
+		if err != nil {
+			return "", err
+		}
+		data = renderDockerfile(pkgs, files)
+	}
+
+	if dir := filepath.Dir(out); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("could not create %s folder: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(out, data, 0644); err != nil {
+		return "", fmt.Errorf("could not write %s: %w", out, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"flk/pkg/flake"
+)
+
+// a flake with jq, strace on linux and steam-run on aarch64-linux only
+func newExportFlake(t *testing.T) *flake.Flake {
+	t.Helper()
+	f, err := flake.New(filepath.Join(t.TempDir(), "flake.nix"), flake.StyleFlakeUtils)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{f.GenerateFlk(), f.GenerateInputs(), f.AddPackage("jq", "")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []struct{ name, platform, system string }{{"strace", "linux", ""}, {"steam-run", "", "aarch64-linux"}, {"cocoapods", "darwin", ""}} {
+		cond, err := flake.PackageCondition(p.platform, p.system)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.AddPackage(p.name, cond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSystemPackages(t *testing.T) {
+	f := newExportFlake(t)
+	for system, want := range map[string][]string{
+		"x86_64-linux":  {"jq", "strace"},
+		"aarch64-linux": {"jq", "strace", "steam-run"},
+	} {
+		got, err := systemPackages(f, system)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("systemPackages(%s) = %q, want %q", system, got, want)
+		}
+	}
+}
+
+func TestRenderDockerfileCopiesExistingFiles(t *testing.T) {
+	f := newExportFlake(t)
+	files, err := devShellFiles(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// there is no flake.lock yet
+	if want := []string{".flk"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("devShellFiles = %q, want %q", files, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(f.Dir(), "flake.lock"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.AddMember("services/api"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	files, err = devShellFiles(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"flake.lock", ".flk", "services/api/flk.nix", "services/api/.flk"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("devShellFiles = %q, want %q", files, want)
+	}
+
+	dockerfile := string(renderDockerfile([]string{"jq"}, files))
+	for _, want := range []string{"COPY flake.nix ./\n", "COPY flake.lock ./flake.lock\n", "COPY services/api/.flk ./services/api/.flk\nRUN nix develop"} {
+		if !strings.Contains(dockerfile, want) {
+			t.Errorf("Dockerfile lacks %q:\n%s", want, dockerfile)
+		}
+	}
+}
+
+func TestExportRejectsOtherSystems(t *testing.T) {
+	f := newExportFlake(t)
+	if _, err := exportFlake(f.Path, "dockerfile", "", "aarch64-darwin", false); exitCode(err) != exitCodes[kindUsage] {
+		t.Errorf("exporting for darwin gave %v, want a usage error", err)
+	}
+}
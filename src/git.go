@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"flk/pkg/flake"
+)
+
+// reports whether dir is inside a git work tree
+func gitWorkTree(dir string) bool {
+	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// returns the paths, relative to dir, that git does not track. Nix leaves them out of the flake.
+func gitUntracked(dir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"ls-files", "-z", "--"}, paths...)...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	tracked := map[string]bool{}
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			tracked[filepath.Clean(p)] = true
+		}
+	}
+	var untracked []string
+	for _, p := range paths {
+		if !tracked[filepath.Clean(p)] {
+			untracked = append(untracked, p)
+		}
+	}
+	return untracked, nil
+}
+
+// untracked files git does not ignore, in dir or limited to paths relative to it
+func gitUntrackedFiles(dir string, paths ...string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"ls-files", "-z", "--others", "--exclude-standard", "--"}, paths...)...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	var files []string
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// stages paths with git add -N, so nix sees them without their content being staged
+func gitAddIntent(dir string, paths []string) error {
+	cmd := exec.Command("git", append([]string{"add", "-N", "--"}, paths...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git add -N failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// the files flk writes for the flake in dir, relative to it
+func flkPaths(dir string) []string {
+	paths := []string{"flake.nix", "flake.lock", ".flk", ".envrc"}
+	f, err := flake.Load(filepath.Join(dir, "flake.nix"))
+	if err != nil {
+		return paths
+	}
+	members, err := f.Members()
+	if err != nil {
+		return paths
+	}
+	for _, m := range members {
+		paths = append(paths, filepath.Join(m.Dir, flake.MemberFile), filepath.Join(m.Dir, ".flk"))
+	}
+	return paths
+}
+
+// directory and untracked flk files before the command ran, so --git-add only stages the files it created
+var (
+	gitAddDir       string
+	untrackedBefore map[string]bool
+)
+
+// remembers the untracked flk files of the flake in dir, does nothing outside a git work tree
+func snapshotUntracked(dir string) error {
+	if !gitWorkTree(dir) {
+		return nil
+	}
+	files, err := gitUntrackedFiles(dir, flkPaths(dir)...)
+	if err != nil {
+		return err
+	}
+	gitAddDir = dir
+	untrackedBefore = map[string]bool{}
+	for _, p := range files {
+		untrackedBefore[p] = true
+	}
+	return nil
+}
+
+// stages the flk files created since snapshotUntracked with git add -N
+func stageCreatedFiles() error {
+	if untrackedBefore == nil {
+		return nil
+	}
+	files, err := gitUntrackedFiles(gitAddDir, flkPaths(gitAddDir)...)
+	if err != nil {
+		return err
+	}
+	var created []string
+	for _, p := range files {
+		if !untrackedBefore[p] {
+			created = append(created, p)
+		}
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	if err := gitAddIntent(gitAddDir, created); err != nil {
+		return err
+	}
+	report("Staged with git add -N:", strings.Join(created, " "))
+	return nil
+}
+
+// untracked files a derivation of f or its members with src = ./. leaves out, nix only copies what git tracks
+func untrackedSources(f *flake.Flake) ([]string, error) {
+	if !gitWorkTree(f.Dir()) {
+		return nil, nil
+	}
+	flakes := []*flake.Flake{f}
+	members, err := f.Members()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		mf, err := flake.Load(f.MemberPath(m))
+		if err != nil {
+			return nil, err
+		}
+		flakes = append(flakes, mf)
+	}
+
+	for _, fl := range flakes {
+		drv, err := fl.Derivation()
+		if err != nil {
+			return nil, err
+		}
+		if drv != nil && drv.Src == "./." {
+			return gitUntrackedFiles(f.Dir())
+		}
+	}
+	return nil, nil
+}
+
+// formats the first few of files
+func summarizeFiles(files []string) string {
+	const shown = 5
+	if len(files) <= shown {
+		return strings.Join(files, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(files[:shown], ", "), len(files)-shown)
+}
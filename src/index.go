@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"flk/pkg/flake"
+)
+
+// a package of the local package index
+type indexedPackage struct {
+	Attr        string `json:"attr"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// returns the local package index, ~/.cache/flk/packages.json
+func packageIndexPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the cache directory: %w", err)
+	}
+	return filepath.Join(dir, "flk", "packages.json"), nil
+}
+
+// builds the package index from nix search, which evaluates all of nixpkgs once
+func buildPackageIndex(nixpkgs string) ([]indexedPackage, error) {
+	bin, err := nixBinary()
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, "search", nixpkgs, "^", "--json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("nix search failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var found map[string]struct {
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &found); err != nil {
+		return nil, &flake.Error{Kind: flake.KindParse, Err: fmt.Errorf("could not parse nix search output: %w", err)}
+	}
+	index := make([]indexedPackage, 0, len(found))
+	for attr, p := range found {
+		// legacyPackages.x86_64-linux.hello
+		if parts := strings.SplitN(attr, ".", 3); len(parts) == 3 {
+			attr = parts[2]
+		}
+		index = append(index, indexedPackage{Attr: attr, Version: p.Version, Description: p.Description})
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].Attr < index[j].Attr })
+
+	path, err := packageIndexPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal package index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// reads the package index, a not-found error when it was never built
+func loadPackageIndex() ([]indexedPackage, error) {
+	path, err := packageIndexPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, &flake.Error{Kind: flake.KindNotFound, Err: fmt.Errorf("no package index, build it with: flk package index")}
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	var index []indexedPackage
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, &flake.Error{Kind: flake.KindParse, Err: fmt.Errorf("could not parse %s: %w", path, err)}
+	}
+	return index, nil
+}
+
+// returns up to limit packages matching query, matches in the attribute name before matches in the description
+func searchPackages(index []indexedPackage, query string, limit int) []indexedPackage {
+	query = strings.ToLower(query)
+	var byAttr, byDescription []indexedPackage
+	for _, p := range index {
+		attr := strings.ToLower(p.Attr)
+		switch {
+		case attr == query:
+			byAttr = append([]indexedPackage{p}, byAttr...)
+		case strings.Contains(attr, query):
+			byAttr = append(byAttr, p)
+		case strings.Contains(strings.ToLower(p.Description), query):
+			byDescription = append(byDescription, p)
+		}
+	}
+	matches := append(byAttr, byDescription...)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// finds the package with attribute attr
+func lookupPackage(index []indexedPackage, attr string) (indexedPackage, bool) {
+	i := sort.Search(len(index), func(i int) bool { return index[i].Attr >= attr })
+	if i < len(index) && index[i].Attr == attr {
+		return index[i], true
+	}
+	return indexedPackage{}, false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flk/pkg/flake"
+)
+
+// runs nix in a directory, swapped out to avoid calling nix
+type nixRunner func(dir string, args ...string) error
+
+var lockRunner nixRunner = runNix
+
+// commit date and age of a locked ref
+func lockAge(r *flake.LockedRef, now time.Time) string {
+	if r == nil || r.LastModified == 0 {
+		return ""
+	}
+	t := time.Unix(r.LastModified, 0)
+	days := int(now.Sub(t).Hours() / 24)
+	return fmt.Sprintf(" (%s, %d days old)", t.UTC().Format("2006-01-02"), days)
+}
+
+// formats the changes as a changelog, one input per line
+func formatLockChanges(changes []flake.LockChange, now time.Time) string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, fmt.Sprintf("%s: %s%s → %s%s", c.Input, c.Old.ShortRev(), lockAge(c.Old, now), c.New.ShortRev(), lockAge(c.New, now)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// updates flake.lock, all inputs when inputs is empty, and returns the changes
+func updateFlakeLock(dir string, inputs []string) ([]flake.LockChange, error) {
+	lockPath := filepath.Join(dir, "flake.lock")
+	old, err := flake.ReadLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// without inputs nix updates all of them
+	args := append([]string{"flake", "update"}, inputs...)
+	if err := lockRunner(dir, args...); err != nil {
+		return nil, err
+	}
+
+	updated, err := flake.ReadLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	return flake.DiffLocks(old, updated), nil
+}
+
+// commits flake.lock with the changelog as message
+func commitFlakeLock(dir, summary string) error {
+	msg := "flake.lock: update\n\n" + summary + "\n"
+	for _, args := range [][]string{
+		{"add", "flake.lock"},
+		{"commit", "-m", msg, "--", "flake.lock"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
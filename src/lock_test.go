@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"flk/pkg/flake"
+)
+
+// a flake.lock with nixpkgs locked to rev
+func writeTestLock(t *testing.T, dir, rev string, lastModified int64) {
+	t.Helper()
+	lock := fmt.Sprintf(`{
+  "nodes": {
+    "nixpkgs": { "locked": { "type": "github", "owner": "NixOS", "repo": "nixpkgs", "rev": %q, "lastModified": %d } },
+    "root": { "inputs": { "nixpkgs": "nixpkgs" } }
+  },
+  "root": "root",
+  "version": 7
+}`, rev, lastModified)
+	if err := os.WriteFile(filepath.Join(dir, "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// swaps lockRunner for one that records its arguments and writes a new flake.lock
+func fakeLockRunner(t *testing.T, rev string) *[]string {
+	t.Helper()
+	var got []string
+	orig := lockRunner
+	lockRunner = func(dir string, args ...string) error {
+		got = args
+		writeTestLock(t, dir, rev, 1700000000)
+		return nil
+	}
+	t.Cleanup(func() { lockRunner = orig })
+	return &got
+}
+
+func TestUpdateFlakeLock(t *testing.T) {
+	tests := []struct {
+		inputs []string
+		args   []string
+	}{
+		{nil, []string{"flake", "update"}},
+		{[]string{"nixpkgs", "flake-utils"}, []string{"flake", "update", "nixpkgs", "flake-utils"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeTestLock(t, dir, "1111111111", 1600000000)
+		args := fakeLockRunner(t, "2222222222")
+
+		changes, err := updateFlakeLock(dir, tt.inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*args, tt.args) {
+			t.Errorf("updateFlakeLock(%q) ran nix %q, want %q", tt.inputs, *args, tt.args)
+		}
+		if len(changes) != 1 || changes[0].Input != "nixpkgs" || changes[0].Old.ShortRev() != "1111111" || changes[0].New.ShortRev() != "2222222" {
+			t.Errorf("updateFlakeLock(%q) = %+v", tt.inputs, changes)
+		}
+	}
+}
+
+func TestUpdateFlakeLockFails(t *testing.T) {
+	orig := lockRunner
+	lockRunner = func(dir string, args ...string) error { return fmt.Errorf("nix flake update failed") }
+	t.Cleanup(func() { lockRunner = orig })
+
+	if _, err := updateFlakeLock(t.TempDir(), nil); err == nil {
+		t.Error("updateFlakeLock ignored the failure of nix")
+	}
+}
+
+func TestFormatLockChanges(t *testing.T) {
+	now := time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)
+	jan1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	changes := []flake.LockChange{
+		{Input: "nixpkgs", Old: &flake.LockedRef{Rev: "1111111111", LastModified: jan1}, New: &flake.LockedRef{Rev: "2222222222"}},
+		{Input: "added", New: &flake.LockedRef{NarHash: "sha256-abcdefghij"}},
+	}
+	want := strings.Join([]string{
+		"nixpkgs: 1111111 (2024-01-01, 10 days old) → 2222222",
+		"added: (none) → abcdefg",
+	}, "\n")
+	if got := formatLockChanges(changes, now); got != want {
+		t.Errorf("formatLockChanges =\n%s\nwant\n%s", got, want)
+	}
+	if got := formatLockChanges(nil, now); got != "" {
+		t.Errorf("formatLockChanges(nil) = %q", got)
+	}
+}
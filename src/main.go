@@ -1,51 +1,88 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"flk/pkg/flake"
 	"github.com/spf13/cobra"
 )
 
-// Boilerplate content for new flake.nix
-var boilerplateContent = `
-{
-  inputs = {
-    nixpkgs.url = "github:NixOS/nixpkgs/nixos-unstable";
-    flake-utils.url = "github:numtide/flake-utils";
-  };
-
-  outputs = { self, nixpkgs, flake-utils, ... }:
-    flake-utils.lib.eachDefaultSystem (system:
-      let
-        pkgs = import nixpkgs { inherit system; };
-      in {
-        devShells = {
-          default = pkgs.mkShell {
-            packages = [
-
-            ];
-
-            shellHook = ''
-              echo "Development environment loaded"
-            '';
-          };
-        };
-      }
-    );
-}
-`
-
 func main() {
-	var file string // --file file
+	// lists and messages are printed with log, without its timestamps
+	log.SetFlags(0)
+
+	var file string            // --file file
+	var workDir string         // --dir services/api
+	var gitAdd bool            // --git-add
+	var systems []string       // --systems x86_64-linux,aarch64-linux
+	var style string           // --style flake-utils|flake-parts
+	var initTemplate string    // --template go
+	var initPname string       // --pname hello
+	var initDescription string // --description "A hello world"
+	var configGlobal bool      // --global
+	var initShellOnly bool     // --shell-only
+	var initInteractive bool   // -i
+	var initYes bool           // --yes
+	var platform string        // --platform linux|darwin
+	var onlySystem string      // --only-system x86_64-linux
+	var overlayFrom string     // --from overlay.nix
+	var cacheKey string        // --key cache.example.com-1:...
+	var commitLock bool        // --commit
+	var importFrom string      // --from devbox|asdf|mise|devenv
+	var exportOut string       // --out Dockerfile
+	var exportForce bool       // --force
+	var exportSystem string    // --system x86_64-linux
+	var moduleService bool     // --service
+	var moduleSettings bool    // --settings
+	var moduleHomeManager bool // --home-manager
 
 	var rootCmd = &cobra.Command{
-		Use:   "flk",
-		Short: "Flk is a simple tool to manage nix files",
+		Use:           "flk",
+		Short:         "Flk is a simple tool to manage nix files",
+		Long:          "Flk is a simple tool to manage nix files\n\n" + exitCodesHelp,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// like git -C, every relative path is taken from --dir
+			if workDir != "" {
+				if err := os.Chdir(workDir); err != nil {
+					return fmt.Errorf("could not change to %s: %w", workDir, err)
+				}
+			}
+			if err := loadConfig(file); err != nil {
+				fail(err)
+			}
+			if !cmd.Flags().Changed("output") {
+				outputFormat = config.Output
+			}
+			if !cmd.Flags().Changed("git-add") {
+				gitAdd = config.GitAdd
+			}
+			if gitAdd {
+				dir, err := configDir(file)
+				if err == nil {
+					err = snapshotUntracked(dir)
+				}
+				if err != nil {
+					fail(err)
+				}
+			}
+			return validateOutputFormat()
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			if gitAdd {
+				if err := stageCreatedFiles(); err != nil {
+					report("warning:", err)
+				}
+			}
+			flushReport()
+		},
 	}
 
 	// `flk flake`
@@ -64,27 +101,212 @@ func main() {
 			if target == "" {
 				target = "flake.nix"
 			}
+			// the project config next to the new flake, not that of a flake above it
+			if err := loadConfig(target); err != nil {
+				fail(err)
+			}
+			if len(systems) == 0 {
+				systems = config.Systems
+			}
+			opts := config.Options()
+			if initShellOnly {
+				opts.NoDerivation = true
+			}
+			absTarget, err := filepath.Abs(target)
+			if err != nil {
+				fail(err)
+			}
+			dir := filepath.Dir(absTarget)
+
+			// the wizard picks the template and fills in the rest
+			var answers wizardAnswers
+			if initInteractive || initYes {
+				if initTemplate != "" || cmd.Flags().Changed("style") {
+					fail(usageError("--interactive picks the template itself, it can not be combined with --template or --style"))
+				}
+				answers = runInitWizard(os.Stdin, os.Stderr, dir, initYes, systems, !opts.NoDerivation)
+				initTemplate = answers.template()
+				initPname = answers.Name
+				systems = answers.Systems
+				opts.NoDerivation = !answers.Derivation
+			}
+
+			var f *flake.Flake
+			if initTemplate != "" {
+				if cmd.Flags().Changed("style") {
+					fail(usageError("--style can not be combined with --template, the template sets the style"))
+				}
+				t, err := flake.FindTemplate(initTemplate)
+				if err != nil {
+					fail(err)
+				}
+				vars := flake.TemplateVars{Pname: initPname, Description: initDescription, Version: answers.Version, Systems: systems, NixpkgsURL: config.NixpkgsURL}
+				if vars.Pname == "" {
+					vars.Pname = filepath.Base(dir)
+				}
+				if f, err = flake.NewFromTemplate(target, t, vars); err != nil {
+					fail(err)
+				}
+				f.Options = opts
+				// templates come with a derivation
+				if opts.NoDerivation {
+					if err := f.DisableDerivation(); err != nil && flake.KindOf(err) != flake.KindNotFound {
+						fail(err)
+					}
+				}
+			} else {
+				var err error
+				if f, err = flake.NewWithOptions(target, style, opts); err != nil {
+					fail(err)
+				}
+			}
+			if len(systems) > 0 {
+				if err := f.SetSystems(systems); err != nil {
+					fail(err)
+				}
+			}
+
+			if initTemplate == "" {
+				if err := f.GenerateFlk(); err != nil {
+					report("warning:", err)
+				}
+			}
+			for _, p := range answers.Packages {
+				if err := f.AddPackage(p, ""); err != nil && flake.KindOf(err) != flake.KindAlreadyExists {
+					fail(err)
+				}
+			}
+
+			if err := f.GenerateInputs(); err != nil {
+				fail(err)
+			}
+			f.Reindent()
+			if err := f.Save(); err != nil {
+				fail(err)
+			}
 
-			// create/write flake first
-			f, err := os.Create(target)
+			if answers.Direnv {
+				created, err := writeEnvrc(dir)
+				if err != nil {
+					fail(err)
+				}
+				if created {
+					report("Created .envrc, allow it with: direnv allow")
+				} else {
+					report("Kept the existing .envrc")
+				}
+			}
+		},
+	}
+
+	// `flk flake convert <style>`
+	var convertCmd = &cobra.Command{
+		Use:       "convert <style>",
+		Short:     "Convert the flake between the flake-utils and flake-parts styles",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{flake.StyleFlakeUtils, flake.StyleFlakeParts},
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
-			_, err = f.Write([]byte(boilerplateContent))
-			f.Close()
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				if err := f.Convert(args[0]); err != nil {
+					return err
+				}
+				return f.GenerateInputs()
+			})
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
+			report("Converted flake to", args[0])
+		},
+	}
 
-			if err := generateFlk(target); err != nil {
-				log.Println(err)
+	// `flk flake status`
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the style, systems, packages and inputs of the flake",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			status, err := getFlakeStatus(filePath)
+			if err != nil {
+				fail(err)
 			}
 
-			filePath, err := resolveFile(target)
+			printResult(status, func() {
+				fmt.Println("File:", status.File)
+				fmt.Println("Style:", status.Style)
+				fmt.Println("Systems:", strings.Join(status.Systems, " "))
+				fmt.Println("Packages:", status.Packages)
+				if status.Derivation != nil {
+					fmt.Println("Derivation:", status.Derivation.Pname, status.Derivation.Version)
+				} else {
+					fmt.Println("Derivation: none")
+				}
+				fmt.Println("Inputs:", status.Inputs)
+				if !status.Locked {
+					fmt.Println("flake.lock: missing")
+				}
+				if status.Stale {
+					fmt.Println(".flk: has changes not applied to flake.nix, run flk flake apply")
+				} else {
+					fmt.Println(".flk: applied")
+				}
+			})
+		},
+	}
+
+	// `flk input`
+	var inputCmd = &cobra.Command{
+		Use:   "input",
+		Short: "Inspect flake inputs",
+	}
+
+	// `flk input list`
+	var inputListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List inputs and their locked revisions",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
-			generateInputs(filePath)
+			f, err := flake.Load(filePath)
+			if err != nil {
+				fail(err)
+			}
+			inputs, err := f.Inputs()
+			if err != nil {
+				fail(err)
+			}
+			if inputs == nil {
+				inputs = []flake.Input{}
+			}
+
+			printResult(map[string]interface{}{"inputs": inputs}, func() {
+				if len(inputs) == 0 {
+					log.Println("No inputs found")
+					return
+				}
+				log.Println("Inputs:")
+				for _, in := range inputs {
+					target := in.URL
+					if in.Follows != "" {
+						target = "follows " + in.Follows
+					}
+					if in.Locked != nil {
+						log.Printf(" - %s %s (%s)", in.Name, target, in.Locked.ShortRev())
+						continue
+					}
+					log.Printf(" - %s %s", in.Name, target)
+				}
+			})
 		},
 	}
 
@@ -103,15 +325,22 @@ func main() {
 			pkg := args[0]
 			filePath, err := resolveFile(file)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
-			err = addPackage(filePath, pkg)
-
+			condition, err := flake.PackageCondition(platform, onlySystem)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
-
-			generateInputs(filePath)
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				if err := f.AddPackage(pkg, condition); err != nil {
+					return err
+				}
+				return f.GenerateInputs()
+			})
+			if err != nil {
+				fail(err)
+			}
+			report("Added package:", pkg)
 		},
 	}
 
@@ -124,11 +353,61 @@ func main() {
 			pkg := args[0]
 			filePath, err := resolveFile(file)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				if err := f.RemovePackage(pkg); err != nil {
+					return err
+				}
+				return f.GenerateInputs()
+			})
+			if err != nil {
+				fail(err)
+			}
+			report("Removed package:", pkg)
+		},
+	}
+
+	// `flk package index`
+	var packageIndexCmd = &cobra.Command{
+		Use:   "index",
+		Short: "Build the local package index searched by flk package search",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			report("Indexing " + config.NixpkgsURL + ", this takes a while")
+			index, err := buildPackageIndex(config.NixpkgsURL)
+			if err != nil {
+				fail(err)
+			}
+
+			report(fmt.Sprintf("Indexed %d packages", len(index)))
+		},
+	}
+
+	// `flk package search <query>`
+	var packageSearchCmd = &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search the local package index",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			index, err := loadPackageIndex()
+			if err != nil {
+				fail(err)
 			}
-			removePackage(filePath, pkg)
+			matches := searchPackages(index, args[0], 20)
 
-			generateInputs(filePath)
+			if matches == nil {
+				matches = []indexedPackage{}
+			}
+			printResult(map[string]interface{}{"packages": matches}, func() {
+				if len(matches) == 0 {
+					log.Printf("No packages match %q", args[0])
+					return
+				}
+				for _, p := range matches {
+					fmt.Printf("%s %s\n    %s\n", p.Attr, p.Version, p.Description)
+				}
+			})
 		},
 	}
 
@@ -139,21 +418,45 @@ func main() {
 		Run: func(cmd *cobra.Command, args []string) {
 			filePath, err := resolveFile(file)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
-			pkgs, err := getPackages(filePath)
+			f, err := flake.Load(filePath)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
+			}
+			pkgs, err := f.Packages()
+			if err != nil {
+				fail(err)
 			}
 
-			if len(pkgs) == 0 {
-				log.Println("No packages found")
-			} else {
+			type packageDoc struct {
+				Name      string `json:"name"`
+				Condition string `json:"condition,omitempty"`
+				OnlyOn    string `json:"onlyOn,omitempty"`
+			}
+			docs := []packageDoc{}
+			for _, p := range pkgs {
+				doc := packageDoc{Name: p.Name, Condition: p.Condition}
+				if p.Condition != "" {
+					doc.OnlyOn = flake.DescribeCondition(p.Condition)
+				}
+				docs = append(docs, doc)
+			}
+
+			printResult(map[string]interface{}{"packages": docs}, func() {
+				if len(pkgs) == 0 {
+					log.Println("No packages found")
+					return
+				}
 				log.Println("Packages:")
 				for _, p := range pkgs {
-					log.Printf(" - %s", p)
+					if p.Condition != "" {
+						log.Printf(" - %s (only on %s)", p.Name, flake.DescribeCondition(p.Condition))
+						continue
+					}
+					log.Printf(" - %s", p.Name)
 				}
-			}
+			})
 		},
 	}
 
@@ -162,202 +465,1060 @@ func main() {
 		Use:   "apply",
 		Short: "Apply changes from .flk to flake.nix",
 		Run: func(cmd *cobra.Command, args []string) {
-			// gets current working directory
-			wd, err := os.Getwd()
+			filePath, err := resolveFile(file)
 			if err != nil {
-				log.Fatal(err)
+				fail(err)
 			}
-			if err := ensureShellHookBlock(wd + "/flake.nix"); err != nil {
-				log.Fatal(err)
+			if err := editFlake(filePath, (*flake.Flake).Apply); err != nil {
+				fail(err)
 			}
-			if err := applyToFlake(wd); err != nil {
-				log.Fatal(err)
+		},
+	}
+
+	// `flk doctor`
+	var doctorCmd = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the flake and .flk for common problems and tell how to fix them",
+		Args:  cobra.NoArgs,
+		// failed checks are in the result, not a misuse
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			diags, err := runDoctor(filePath)
+			if err != nil {
+				fail(err)
 			}
 
+			failed := 0
+			for _, d := range diags {
+				if d.Status == flake.StatusError {
+					failed++
+				}
+			}
+			printResult(map[string]interface{}{"checks": diags}, func() {
+				for _, d := range diags {
+					fmt.Printf("[%s] %s: %s\n", d.Status, d.Check, d.Message)
+					if d.Fix != "" {
+						fmt.Printf("    fix: %s\n", d.Fix)
+					}
+				}
+			})
+			if failed > 0 {
+				return &flake.Error{Kind: flake.KindError, Err: fmt.Errorf("%d of %d checks failed", failed, len(diags))}
+			}
+			return nil
 		},
 	}
 
-	// Add --file flag to subcommands
-	addCmd.Flags().StringVarP(&file, "file", "f", "", "Path to flake.nix file")
-	removeCmd.Flags().StringVarP(&file, "file", "f", "", "Path to flake.nix file")
-	listCmd.Flags().StringVarP(&file, "file", "f", "", "Path to flake.nix file")
-	initCmd.Flags().StringVarP(&file, "file", "f", "", "Path to flake.nix file")
+	// `flk watch`
+	var watchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Apply .flk to flake.nix whenever a file in it changes",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			if err := watchFlk(filePath); err != nil {
+				fail(err)
+			}
+		},
+	}
 
-	// Command tree
-	flakeCmd.AddCommand(initCmd)
-	flakeCmd.AddCommand(applyCmd)
-	packageCmd.AddCommand(addCmd, removeCmd, listCmd)
-	rootCmd.AddCommand(flakeCmd, packageCmd)
+	// `flk systems`
+	var systemsCmd = &cobra.Command{
+		Use:   "systems",
+		Short: "Manage the systems outputs are generated for",
+	}
 
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+	// `flk systems set <system>...`
+	var systemsSetCmd = &cobra.Command{
+		Use:   "set <system>...",
+		Short: "Set the systems, replacing flake-utils with a forAllSystems helper",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				if err := f.SetSystems(args); err != nil {
+					return err
+				}
+				return f.GenerateInputs()
+			})
+			if err != nil {
+				fail(err)
+			}
+			report("Set systems:", strings.Join(args, " "))
+		},
 	}
-}
 
-// returns the file path to use e.g the flake.nix
-func resolveFile(flag string) (string, error) {
-	if flag != "" {
-		return flag, nil
+	// `flk systems list`
+	var systemsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the systems",
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			f, err := flake.Load(filePath)
+			if err != nil {
+				fail(err)
+			}
+			list, err := f.Systems()
+			if err != nil {
+				fail(err)
+			}
+
+			printResult(map[string]interface{}{"systems": list}, func() {
+				log.Println("Systems:")
+				for _, s := range list {
+					log.Printf(" - %s", s)
+				}
+			})
+		},
 	}
 
-	defaultPath := "flake.nix"
-	if _, err := os.Stat(defaultPath); err == nil {
-		absPath, err := filepath.Abs(defaultPath)
-		if err != nil {
-			return "", fmt.Errorf("could not resolve flake.nix path: %w", err)
-		}
-		return absPath, nil
+	// `flk overlay`
+	var overlayCmd = &cobra.Command{
+		Use:   "overlay",
+		Short: "Manage nixpkgs overlays in .flk/overlays",
 	}
 
-	return "", fmt.Errorf("no --file given and flake.nix not found in current directory")
-}
+	// `flk overlay add <name>`
+	var overlayAddCmd = &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add an overlay and apply it to pkgs",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				return f.AddOverlay(args[0], overlayFrom)
+			})
+			if err != nil {
+				fail(err)
+			}
 
-// function that makes a .flk folder and extracts shellHook from the provided flake file
-func generateFlk(flakePath string) error {
-	shellHookLines, shErr := getLinesBetween(flakePath, "shellHook = ''", "'';")
+			report("Added overlay:", args[0])
+		},
+	}
 
-	pkgs, pkErr := getPackages(flakePath)
+	// `flk overlay remove <name>`
+	var overlayRemoveCmd = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove an overlay",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				return f.RemoveOverlay(args[0])
+			})
+			if err != nil {
+				fail(err)
+			}
 
-	if shErr != nil && (pkErr != nil || len(pkgs) == 0) {
-		return nil
+			report("Removed overlay:", args[0])
+		},
 	}
 
-	// ensure .flk exists
-	if err := os.MkdirAll(".flk", 0755); err != nil {
-		return fmt.Errorf("could not create .flk folder: %w", err)
+	// `flk overlay list`
+	var overlayListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all overlays",
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			f, err := flake.Load(filePath)
+			if err != nil {
+				fail(err)
+			}
+			names, err := f.Overlays()
+			if err != nil {
+				fail(err)
+			}
+
+			if names == nil {
+				names = []string{}
+			}
+			printResult(map[string]interface{}{"overlays": names}, func() {
+				if len(names) == 0 {
+					log.Println("No overlays found")
+					return
+				}
+				log.Println("Overlays:")
+				for _, n := range names {
+					log.Printf(" - %s", n)
+				}
+			})
+		},
 	}
-	// ensure .flk/devenv exists
-	if err := os.MkdirAll(".flk/devenv", 0755); err != nil {
-		return fmt.Errorf("could not create .flk/devenv folder: %w", err)
+
+	// `flk nixpkgs`
+	var nixpkgsCmd = &cobra.Command{
+		Use:   "nixpkgs",
+		Short: "Manage the nixpkgs config in .flk/nixpkgs.yml",
 	}
 
-	// If shellHook was found, write it out
-	if shErr == nil {
-		outf, err := os.Create(".flk/devenv/shellhook.sh")
-		if err != nil {
-			return fmt.Errorf("could not create .flk/devenv/shellhook.sh: %w", err)
-		}
-		defer outf.Close()
+	// `flk nixpkgs allow-unfree [package...]`
+	var allowUnfreeCmd = &cobra.Command{
+		Use:   "allow-unfree [package...]",
+		Short: "Allow unfree packages, or only the given ones",
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				return f.AllowUnfree(args)
+			})
+			if err != nil {
+				fail(err)
+			}
 
-		for _, line := range shellHookLines {
-			if _, err := outf.WriteString(line + "\n"); err != nil {
-				return fmt.Errorf("could not write to .flk/devenv/shellhook.sh: %w", err)
+			if len(args) == 0 {
+				report("Allowed all unfree packages")
+			} else {
+				report("Allowed unfree packages:", strings.Join(args, " "))
 			}
-		}
+		},
 	}
 
-	// make .flk/derivation
-	if err := os.MkdirAll(".flk/derivation", 0755); err != nil {
-		return fmt.Errorf("could not create .flk/derivation folder: %w", err)
+	// `flk nixpkgs permit-insecure <package>`
+	var permitInsecureCmd = &cobra.Command{
+		Use:   "permit-insecure <package>",
+		Short: "Permit an insecure package, e.g. openssl-1.1.1w",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				return f.PermitInsecure(args[0])
+			})
+			if err != nil {
+				fail(err)
+			}
+
+			report("Permitted insecure package:", args[0])
+		},
 	}
 
-	// Write a YAML file with pname, version, src, and packages fields
-	yf, err := os.Create(".flk/derivation/package.yml")
-	if err != nil {
-		return fmt.Errorf("could not create .flk/derivation/package.yml: %w", err)
+	// `flk cache`
+	var cacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "Manage binary caches in nixConfig",
 	}
-	defer yf.Close()
 
-	pname := "default"
-	version := "0.1"
-	src := "./."
+	// `flk cache add <url>`
+	var cacheAddCmd = &cobra.Command{
+		Use:   "add <url>",
+		Short: "Add a binary cache",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				return f.AddCache(args[0], cacheKey)
+			})
+			if err != nil {
+				fail(err)
+			}
+
+			report("Added cache:", args[0])
+		},
+	}
+
+	// `flk cache remove <url>`
+	var cacheRemoveCmd = &cobra.Command{
+		Use:   "remove <url>",
+		Short: "Remove a binary cache and its keys",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				return f.RemoveCache(args[0], cacheKey)
+			})
+			if err != nil {
+				fail(err)
+			}
+
+			report("Removed cache:", args[0])
+		},
+	}
+
+	// `flk cache list`
+	var cacheListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all binary caches",
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			f, err := flake.Load(filePath)
+			if err != nil {
+				fail(err)
+			}
+			cfg, err := f.NixConfig()
+			if err != nil {
+				fail(err)
+			}
 
-	if _, err := yf.WriteString(fmt.Sprintf("pname: %s\nversion: %s\nsrc: %s\npackages:\n", pname, version, src)); err != nil {
-		return fmt.Errorf("could not write to .flk/derivation/package.yml: %w", err)
+			doc := map[string]interface{}{
+				"substituters":      append([]string{}, cfg.Substituters...),
+				"trustedPublicKeys": append([]string{}, cfg.TrustedPublicKeys...),
+			}
+			printResult(doc, func() {
+				if len(cfg.Substituters) == 0 {
+					log.Println("No caches found")
+					return
+				}
+				log.Println("Caches:")
+				for _, s := range cfg.Substituters {
+					log.Printf(" - %s", s)
+				}
+				if len(cfg.TrustedPublicKeys) > 0 {
+					log.Println("Trusted public keys:")
+					for _, k := range cfg.TrustedPublicKeys {
+						log.Printf(" - %s", k)
+					}
+				}
+			})
+		},
 	}
-	if pkErr == nil && len(pkgs) > 0 {
-		for _, p := range pkgs {
-			if _, err := yf.WriteString("  - " + p + "\n"); err != nil {
-				return fmt.Errorf("could not write to .flk/package.yml: %w", err)
+
+	// wraps `nix <sub>`, applying .flk first when it is newer than flake.nix
+	nixWrapper := func(sub []string, withRef bool) func(cmd *cobra.Command, args []string) {
+		return func(cmd *cobra.Command, args []string) {
+			dir, err := resolveDir(file)
+			if err != nil {
+				fail(err)
+			}
+			attr, extra := splitWrapperArgs(args, cmd.ArgsLenAtDash())
+			// inside a workspace member its outputs are the default
+			if attr == "" && withRef {
+				if attr, err = resolveMember(file); err != nil {
+					fail(err)
+				}
+			}
+			if attr == "" && sub[0] == "develop" {
+				if attr, err = defaultShellAttr(dir); err != nil {
+					fail(err)
+				}
+			}
+			if err := runNixWrapper(dir, sub, attr, withRef, extra); err != nil {
+				fail(err)
 			}
 		}
 	}
 
-	// Ensure mkDerivation block exists
-	if err := ensureDerivation(flakePath); err != nil {
-		return fmt.Errorf("could not ensure derivation in %s: %w", flakePath, err)
+	// `flk build [attr]`
+	var buildCmd = &cobra.Command{
+		Use:   "build [attr] [-- nix args]",
+		Short: "Apply .flk if needed and run nix build",
+		Args:  attrBeforeDash,
+		Run:   nixWrapper([]string{"build"}, true),
 	}
 
-	// Make a build.sh in /derivation
-	bf, err := os.Create(".flk/derivation/build.sh")
-	if err != nil {
-		return fmt.Errorf("could not create .flk/derivation/build.sh: %w", err)
+	// `flk develop [attr]`
+	var developCmd = &cobra.Command{
+		Use:   "develop [attr] [-- nix args]",
+		Short: "Apply .flk if needed and run nix develop",
+		Args:  attrBeforeDash,
+		Run:   nixWrapper([]string{"develop"}, true),
 	}
-	defer bf.Close()
-	if err := applyBuildPhaseScript(bf.Name()); err != nil {
-		return fmt.Errorf("could not apply build phase script: %w", err)
+
+	// `flk run [attr]`
+	var runCmd = &cobra.Command{
+		Use:   "run [attr] [-- program args]",
+		Short: "Apply .flk if needed and run nix run",
+		Args:  attrBeforeDash,
+		Run:   nixWrapper([]string{"run"}, true),
 	}
 
-	// Make an install.sh in /derivation
-	instf, err := os.Create(".flk/derivation/install.sh")
-	if err != nil {
-		return fmt.Errorf("could not create .flk/derivation/install.sh: %w", err)
+	// `flk check`
+	var checkCmd = &cobra.Command{
+		Use:   "check [-- nix args]",
+		Short: "Apply .flk if needed and run nix flake check",
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := resolveDir(file)
+			if err != nil {
+				fail(err)
+			}
+			if err := runNixWrapper(dir, []string{"flake", "check"}, "", false, args); err != nil {
+				fail(err)
+			}
+		},
 	}
-	defer instf.Close()
 
-	// Write default install.sh content
-	defaultInstallContent := `
+	// `flk image`
+	var imageCmd = &cobra.Command{
+		Use:   "image",
+		Short: "Manage the container image built from the derivation",
+	}
 
-	`
-	if _, err := instf.WriteString(defaultInstallContent); err != nil {
-		return fmt.Errorf("could not write to .flk/derivation/install.sh: %w", err)
+	// `flk image enable`
+	var imageEnableCmd = &cobra.Command{
+		Use:   "enable",
+		Short: "Create .flk/image.yml and add packages.image to the flake",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			if err := editFlake(filePath, (*flake.Flake).EnableImage); err != nil {
+				fail(err)
+			}
+
+			report("Enabled image, build it with: flk build image")
+		},
 	}
 
-	if err := applyInstallPhaseScript(instf.Name()); err != nil {
-		return fmt.Errorf("could not apply install phase script: %w", err)
+	// `flk module`
+	var moduleCmd = &cobra.Command{
+		Use:   "module",
+		Short: "Manage NixOS and home-manager modules in .flk/modules",
 	}
 
-	return nil
-}
+	// `flk module init`
+	var moduleInitCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Create a module for the derivation and export it as nixosModules.default",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			var created []string
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				var err error
+				created, err = f.InitModules(moduleService, moduleSettings, moduleHomeManager)
+				return err
+			})
+			if err != nil {
+				fail(err)
+			}
 
-func getLinesBetween(filePath, start, end string) ([]string, error) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("could not open file: %w", err)
+			for _, path := range created {
+				report("Created module:", path)
+			}
+		},
+	}
+
+	// `flk derivation`
+	var derivationCmd = &cobra.Command{
+		Use:   "derivation",
+		Short: "Add or remove the derivation built from .flk/derivation",
+	}
+
+	// `flk derivation enable`
+	var derivationEnableCmd = &cobra.Command{
+		Use:   "enable",
+		Short: "Add the derivation and its .flk/derivation files",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			if err := editFlake(filePath, (*flake.Flake).EnableDerivation); err != nil {
+				fail(err)
+			}
+
+			report("Enabled derivation, edit it in .flk/derivation")
+		},
+	}
+
+	// `flk derivation disable`
+	var derivationDisableCmd = &cobra.Command{
+		Use:   "disable",
+		Short: "Remove the derivation and the .flk/derivation folder",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
+			}
+			if err := editFlake(filePath, (*flake.Flake).DisableDerivation); err != nil {
+				fail(err)
+			}
+
+			report("Disabled derivation")
+		},
+	}
+
+	// `flk workspace`
+	var workspaceCmd = &cobra.Command{
+		Use:   "workspace",
+		Short: "Manage workspace members listed in .flk/workspace.yml",
 	}
-	defer f.Close()
 
-	trimmedStart := strings.TrimSpace(start)
-	trimmedEnd := strings.TrimSpace(end)
+	// `flk workspace add <dir...>`
+	var workspaceAddCmd = &cobra.Command{
+		Use:   "add <dir...>",
+		Short: "Add directories as members, exposed as packages.<dir> and devShells.<dir>",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveRoot(file)
+			if err != nil {
+				fail(err)
+			}
+			var added []flake.Member
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				for _, arg := range args {
+					dir, err := memberArg(f, arg)
+					if err != nil {
+						return err
+					}
+					m, err := f.AddMember(dir)
+					if err != nil {
+						return err
+					}
+					added = append(added, m)
+				}
+				return nil
+			})
+			if err != nil {
+				fail(err)
+			}
+
+			for _, m := range added {
+				report(fmt.Sprintf("Added workspace member: %s (%s)", m.Name, m.Dir))
+			}
+		},
+	}
+
+	// `flk workspace remove <dir>`
+	var workspaceRemoveCmd = &cobra.Command{
+		Use:   "remove <dir>",
+		Short: "Remove a member from the workspace, keeping its files",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveRoot(file)
+			if err != nil {
+				fail(err)
+			}
+			err = editFlake(filePath, func(f *flake.Flake) error {
+				dir, err := memberArg(f, args[0])
+				if err != nil {
+					return err
+				}
+				return f.RemoveMember(dir)
+			})
+			if err != nil {
+				fail(err)
+			}
+
+			report("Removed workspace member:", args[0])
+		},
+	}
+
+	// `flk workspace list`
+	var workspaceListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the workspace members",
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveRoot(file)
+			if err != nil {
+				fail(err)
+			}
+			f, err := flake.Load(filePath)
+			if err != nil {
+				fail(err)
+			}
+			members, err := f.Members()
+			if err != nil {
+				fail(err)
+			}
+
+			if members == nil {
+				members = []flake.Member{}
+			}
+			printResult(map[string]interface{}{"members": members}, func() {
+				if len(members) == 0 {
+					log.Println("No workspace members found")
+					return
+				}
+				log.Println("Workspace members:")
+				for _, m := range members {
+					log.Printf(" - %s (%s)", m.Name, m.Dir)
+				}
+			})
+		},
+	}
 
-	scanner := bufio.NewScanner(f)
-	var lines []string
-	capturing := false
+	// `flk template`
+	var templateCmd = &cobra.Command{
+		Use:   "template",
+		Short: "Manage the templates flk flake init --template starts from",
+	}
 
-	for scanner.Scan() {
-		raw := scanner.Text()
-		t := strings.TrimSpace(raw)
+	// `flk template list`
+	var templateListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List built-in and user templates",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			templates, err := flake.Templates()
+			if err != nil {
+				fail(err)
+			}
 
-		if !capturing {
-			if strings.Contains(t, trimmedStart) {
-				capturing = true
-				if idx := strings.Index(t, trimmedStart); idx != -1 {
-					rest := strings.TrimSpace(t[idx+len(trimmedStart):])
-					if rest != "" {
-						lines = append(lines, rest)
+			printResult(map[string]interface{}{"templates": templates}, func() {
+				log.Println("Templates:")
+				for _, t := range templates {
+					line := " - " + t.Name
+					if t.Description != "" {
+						line += ": " + t.Description
 					}
+					if t.Dir != "" {
+						line += " (" + t.Dir + ")"
+					}
+					log.Print(line)
+				}
+			})
+		},
+	}
+
+	// `flk template show <name>`
+	var templateShowCmd = &cobra.Command{
+		Use:   "show <name>",
+		Short: "Print the files of a template",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			t, err := flake.FindTemplate(args[0])
+			if err != nil {
+				fail(err)
+			}
+			files, err := t.Files()
+			if err != nil {
+				fail(err)
+			}
+
+			printResult(map[string]interface{}{"template": t, "files": files}, func() {
+				names := make([]string, 0, len(files))
+				for name := range files {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for i, name := range names {
+					if i > 0 {
+						fmt.Println()
+					}
+					fmt.Printf("==> %s <==\n", name)
+					fmt.Print(files[name])
+				}
+			})
+		},
+	}
+
+	// `flk template add <name> <dir|git url>`
+	var templateAddCmd = &cobra.Command{
+		Use:   "add <name> <dir|git url>",
+		Short: "Copy a template from a directory or git repository to ~/.config/flk/templates",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			t, err := addTemplate(args[0], args[1])
+			if err != nil {
+				fail(err)
+			}
+
+			report("Added template:", t.Name)
+		},
+	}
+
+	// `flk config`
+	var configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Read and change ~/.config/flk/config.yml and .flk/config.yml",
+		Long: "Read and change the flk configuration.\n\n" +
+			"Settings are layered, later ones win: the defaults, ~/.config/flk/config.yml,\n" +
+			".flk/config.yml of the project, FLK_* environment variables and command line flags.",
+	}
+
+	// `flk config get <key>`
+	var configGetCmd = &cobra.Command{
+		Use:       "get <key>",
+		Short:     "Print the value of a key",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: flake.ConfigKeys(),
+		Run: func(cmd *cobra.Command, args []string) {
+			value, err := config.Get(args[0])
+			if err != nil {
+				fail(err)
+			}
+
+			printResult(map[string]interface{}{"key": args[0], "value": value, "source": config.Sources[args[0]]}, func() {
+				fmt.Println(value)
+			})
+		},
+	}
+
+	// `flk config set <key> <value>`
+	var configSetCmd = &cobra.Command{
+		Use:       "set <key> <value>",
+		Short:     "Set a key in .flk/config.yml, or ~/.config/flk/config.yml with --global",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: flake.ConfigKeys(),
+		Run: func(cmd *cobra.Command, args []string) {
+			var path string
+			if configGlobal {
+				var err error
+				if path, err = flake.UserConfigPath(); err != nil {
+					fail(err)
+				}
+			} else {
+				dir, err := configDir(file)
+				if err != nil {
+					fail(err)
+				}
+				path = flake.ProjectConfigPath(dir)
+			}
+			if err := flake.SetConfig(path, args[0], args[1]); err != nil {
+				fail(err)
+			}
+
+			report(fmt.Sprintf("Set %s in %s", args[0], path))
+			if env, _ := flake.ConfigEnv(args[0]); os.Getenv(env) != "" {
+				report(fmt.Sprintf("warning: %s is set and takes precedence", env))
+			}
+		},
+	}
+
+	// `flk config list`
+	var configListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List every key with its value and where it was set",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			type entry struct {
+				Key    string `json:"key"`
+				Value  string `json:"value"`
+				Source string `json:"source"`
+			}
+			var entries []entry
+			for _, key := range flake.ConfigKeys() {
+				value, err := config.Get(key)
+				if err != nil {
+					fail(err)
+				}
+				entries = append(entries, entry{key, value, config.Sources[key]})
+			}
+
+			printResult(map[string]interface{}{"config": entries}, func() {
+				for _, e := range entries {
+					fmt.Printf("%s = %s (%s)\n", e.Key, e.Value, e.Source)
+				}
+			})
+		},
+	}
+
+	// `flk lock`
+	var lockCmd = &cobra.Command{
+		Use:   "lock",
+		Short: "Manage flake.lock",
+	}
+
+	// `flk lock update [input...]`
+	var lockUpdateCmd = &cobra.Command{
+		Use:   "update [input...]",
+		Short: "Update inputs and print what changed",
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := resolveDir(file)
+			if err != nil {
+				fail(err)
+			}
+			changes, err := updateFlakeLock(dir, args)
+			if err != nil {
+				fail(err)
+			}
+
+			summary := formatLockChanges(changes, time.Now())
+			committed := false
+			if commitLock && len(changes) > 0 {
+				if err := commitFlakeLock(dir, summary); err != nil {
+					fail(err)
 				}
-				continue
+				committed = true
+			}
+
+			if changes == nil {
+				changes = []flake.LockChange{}
 			}
-		} else {
-			if strings.Contains(t, trimmedEnd) {
-				if idx := strings.Index(t, trimmedEnd); idx > 0 {
-					prefix := strings.TrimSpace(t[:idx])
-					if prefix != "" {
-						lines = append(lines, prefix)
+			printResult(map[string]interface{}{"changes": changes, "committed": committed}, func() {
+				if len(changes) == 0 {
+					fmt.Println("All inputs are up to date")
+					return
+				}
+				fmt.Println(summary)
+				if committed {
+					fmt.Println("Committed flake.lock")
+				}
+			})
+		},
+	}
+
+	// `flk import <file>`
+	var importCmd = &cobra.Command{
+		Use:   "import [shell.nix|default.nix|file]",
+		Short: "Generate a flake and .flk from a legacy nix file or another tool's config",
+		Long: "Without --from, imports a legacy shell.nix or default.nix into a new flake. Package lists written\n" +
+			"with `with pkgs;` keep that style, and a derivation is only created for mkDerivation. Packages the flake\n" +
+			"already has are skipped with a warning.\n" +
+			"With --from devbox|asdf|mise|devenv, adds that tool's packages and init hook to the flake. For devenv only\n" +
+			"devenv.nix is imported, the inputs in devenv.yaml are left out.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			target := file
+			if target == "" {
+				target = "flake.nix"
+			}
+
+			var result *flake.Import
+			var source string
+			if importFrom == "" {
+				if len(args) == 0 {
+					fail(usageError("no file given, pass a shell.nix or default.nix or use --from"))
+				}
+				source = args[0]
+				r, err := flake.ImportLegacy(source, target, config.Options())
+				if err != nil {
+					fail(err)
+				}
+				result = r
+			} else {
+				source = flake.ImportSources[importFrom]
+				if len(args) == 1 {
+					source = args[0]
+				} else if importFrom == "mise" {
+					if _, err := os.Stat(source); err != nil {
+						source = ".mise.toml"
 					}
 				}
-				return lines, nil
+				r, err := flake.ImportTool(importFrom, source, target, config.Options())
+				if err != nil {
+					fail(err)
+				}
+				result = r
+			}
+
+			warnings := append([]string{}, result.Warnings...)
+			printResult(map[string]interface{}{"source": source, "target": target, "warnings": warnings}, func() {
+				for _, w := range warnings {
+					log.Println("warning:", w)
+				}
+				fmt.Println("Imported", source, "into", target)
+			})
+		},
+	}
+
+	// `flk export <devcontainer|dockerfile>`
+	var exportCmd = &cobra.Command{
+		Use:       "export <devcontainer|dockerfile>",
+		Short:     "Generate a devcontainer.json or Dockerfile from the devShell",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"devcontainer", "dockerfile"},
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, err := resolveFile(file)
+			if err != nil {
+				fail(err)
 			}
-			if t != "" {
-				lines = append(lines, t)
+			out, err := exportFlake(filePath, args[0], exportOut, exportSystem, exportForce)
+			if err != nil {
+				fail(err)
+			}
+
+			report("Exported", args[0], "to", out)
+		},
+	}
+
+	// Flags
+	addCmd.Flags().StringVar(&platform, "platform", "", "Only add the package on this platform (linux or darwin)")
+	addCmd.Flags().StringVar(&onlySystem, "only-system", "", "Only add the package on this system, e.g. x86_64-linux")
+	initCmd.Flags().StringVar(&style, "style", flake.StyleFlakeUtils, "Output style, flake-utils or flake-parts")
+	initCmd.Flags().StringSliceVar(&systems, "systems", nil, "Generate a flake-utils-free forAllSystems helper for these systems")
+	initCmd.Flags().BoolVarP(&initInteractive, "interactive", "i", false, "Ask for the name, language, systems and packages of the project")
+	initCmd.Flags().BoolVar(&initYes, "yes", false, "Run the interactive setup taking every default")
+	initCmd.Flags().BoolVar(&initShellOnly, "shell-only", false, "Only create the devShell, without a derivation")
+	initCmd.Flags().StringVar(&initTemplate, "template", "", "Start from a built-in or user template, see flk template list")
+	initCmd.Flags().StringVar(&initPname, "pname", "", "Package name for the template, the directory name by default")
+	initCmd.Flags().StringVar(&initDescription, "description", "", "Flake description for the template")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputText, "Output format, text or json")
+	rootCmd.PersistentFlags().StringVarP(&file, "file", "f", "", "Path to flake.nix file, found by searching upward when not given")
+	rootCmd.PersistentFlags().StringVarP(&workDir, "dir", "C", "", "Run as if flk was started in this directory")
+	rootCmd.PersistentFlags().BoolVar(&gitAdd, "git-add", false, "Stage the files flk creates with git add -N, so nix sees them")
+	overlayAddCmd.Flags().StringVar(&overlayFrom, "from", "", "Copy the overlay from this file instead of creating an empty one")
+	cacheAddCmd.Flags().StringVar(&cacheKey, "key", "", "Public key of the cache")
+	cacheRemoveCmd.Flags().StringVar(&cacheKey, "key", "", "Public key to remove as well")
+	importCmd.Flags().StringVar(&importFrom, "from", "", "Import from devbox, asdf, mise or devenv")
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "Output path")
+	exportCmd.Flags().BoolVar(&exportForce, "force", false, "Overwrite an existing file")
+	exportCmd.Flags().StringVar(&exportSystem, "system", "x86_64-linux", "System of the container, packages only added on other systems are left out")
+	moduleInitCmd.Flags().BoolVar(&moduleService, "service", false, "Run the derivation as a systemd service")
+	moduleInitCmd.Flags().BoolVar(&moduleSettings, "settings", false, "Give the service a settings option, passed as --config <pname>.json")
+	moduleInitCmd.Flags().BoolVar(&moduleHomeManager, "home-manager", false, "Also create a home-manager module")
+	lockUpdateCmd.Flags().BoolVar(&commitLock, "commit", false, "Commit flake.lock with the changes as message")
+	configSetCmd.Flags().BoolVar(&configGlobal, "global", false, "Write ~/.config/flk/config.yml instead of the project config")
+
+	// Command tree
+	flakeCmd.AddCommand(initCmd)
+	flakeCmd.AddCommand(applyCmd, convertCmd, statusCmd)
+	inputCmd.AddCommand(inputListCmd)
+	packageCmd.AddCommand(addCmd, removeCmd, listCmd, packageIndexCmd, packageSearchCmd)
+	systemsCmd.AddCommand(systemsSetCmd, systemsListCmd)
+	overlayCmd.AddCommand(overlayAddCmd, overlayRemoveCmd, overlayListCmd)
+	nixpkgsCmd.AddCommand(allowUnfreeCmd, permitInsecureCmd)
+	cacheCmd.AddCommand(cacheAddCmd, cacheRemoveCmd, cacheListCmd)
+	lockCmd.AddCommand(lockUpdateCmd)
+	imageCmd.AddCommand(imageEnableCmd)
+	moduleCmd.AddCommand(moduleInitCmd)
+	workspaceCmd.AddCommand(workspaceAddCmd, workspaceRemoveCmd, workspaceListCmd)
+	templateCmd.AddCommand(templateListCmd, templateShowCmd, templateAddCmd)
+	configCmd.AddCommand(configGetCmd, configSetCmd, configListCmd)
+	derivationCmd.AddCommand(derivationEnableCmd, derivationDisableCmd)
+	rootCmd.AddCommand(flakeCmd, packageCmd, systemsCmd, overlayCmd, nixpkgsCmd, cacheCmd, lockCmd, imageCmd, moduleCmd, derivationCmd, workspaceCmd, templateCmd, configCmd, inputCmd)
+	rootCmd.AddCommand(buildCmd, developCmd, runCmd, checkCmd, doctorCmd, watchCmd, importCmd, exportCmd)
+
+	// errors returned by cobra itself are usage errors, commands exit through fail or return a flake.Error once
+	// their result is printed
+	if err := rootCmd.Execute(); err != nil {
+		var fe *flake.Error
+		if !errors.As(err, &fe) {
+			fail(&flake.Error{Kind: kindUsage, Err: err})
+		}
+		// cobra skips the post hooks of a command that returned an error
+		rootCmd.PersistentPostRun(rootCmd, nil)
+		fail(err)
+	}
+}
+
+// returns the file path to use e.g the flake.nix, searching upward from the working directory like git does for .git
+func resolveFile(flag string) (string, error) {
+	if flag != "" {
+		return flag, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("could not resolve flake.nix path: %w", err)
+	}
+	// a workspace member is closer than the flake.nix at the workspace root
+	if path, ok := findUp(wd, flake.MemberFile, "flake.nix"); ok {
+		return path, nil
+	}
+
+	return "", &flake.Error{Kind: flake.KindNotFound, Err: fmt.Errorf("no --file given and no flake.nix found in %s or any parent directory", wd)}
+}
+
+// returns the flake.nix at the workspace root when the resolved file is a workspace member
+func resolveRoot(flag string) (string, error) {
+	filePath, err := resolveFile(flag)
+	if err != nil || filepath.Base(filePath) != flake.MemberFile {
+		return filePath, err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve flake.nix path: %w", err)
+	}
+	if path, ok := findUp(filepath.Dir(filepath.Dir(absPath)), "flake.nix"); ok {
+		return path, nil
+	}
+	return "", &flake.Error{Kind: flake.KindNotFound, Err: fmt.Errorf("no flake.nix found above workspace member %s", filePath)}
+}
+
+// returns the workspace member the resolved file belongs to, "" for a flake.nix
+func resolveMember(flag string) (string, error) {
+	filePath, err := resolveFile(flag)
+	if err != nil || filepath.Base(filePath) != flake.MemberFile {
+		return "", err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s path: %w", flake.MemberFile, err)
+	}
+	return filepath.Base(filepath.Dir(absPath)), nil
+}
+
+// returns the first of names found in start or any parent directory
+func findUp(start string, names ...string) (string, bool) {
+	for dir := start; ; dir = filepath.Dir(dir) {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, true
 			}
 		}
+		if filepath.Dir(dir) == dir {
+			return "", false
+		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error scanning file: %w", err)
+// returns the absolute directory holding the flake, where nix runs. For workspace members that is the workspace root.
+func resolveDir(flag string) (string, error) {
+	filePath, err := resolveRoot(flag)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve flake.nix path: %w", err)
 	}
+	return filepath.Dir(absPath), nil
+}
 
-	return nil, fmt.Errorf("end marker not found")
+// makes a member directory given relative to the working directory relative to the workspace root
+func memberArg(f *flake.Flake, arg string) (string, error) {
+	absArg, err := filepath.Abs(arg)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %w", arg, err)
+	}
+	absDir, err := filepath.Abs(f.Dir())
+	if err != nil {
+		return "", fmt.Errorf("could not resolve flake.nix path: %w", err)
+	}
+	// Rel only fails across volumes, paths outside the root come back with ..
+	rel, err := filepath.Rel(absDir, absArg)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", usageError("%s is not inside the workspace at %s", arg, absDir)
+	}
+	if rel == "." {
+		return "", usageError("%s is the workspace root, not a member", arg)
+	}
+	return rel, nil
+}
+
+// loads the flake at filePath, runs edit on it and writes it back
+func editFlake(filePath string, edit func(f *flake.Flake) error) error {
+	f, err := flake.Load(filePath)
+	if err != nil {
+		return err
+	}
+	f.Options = config.Options()
+	if err := edit(f); err != nil {
+		return err
+	}
+	return f.Save()
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"flk/pkg/flake"
+)
+
+func TestMemberArg(t *testing.T) {
+	dir := t.TempDir()
+	f, err := flake.New(filepath.Join(dir, "flake.nix"), flake.StyleFlakeUtils)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := memberArg(f, filepath.Join(dir, "services", "api"))
+	if err != nil || got != filepath.Join("services", "api") {
+		t.Errorf("a member directory gave %q, %v", got, err)
+	}
+	for _, arg := range []string{filepath.Join(dir, "..", "other"), filepath.Dir(dir), dir} {
+		if _, err := memberArg(f, arg); exitCode(err) != exitCodes[kindUsage] {
+			t.Errorf("%s gave %v, want a usage error", arg, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"flk/pkg/flake"
+	"github.com/spf13/cobra"
+)
+
+// .flk scripts each phase is generated from
+var phaseScripts = map[string]string{
+	"buildPhase":   ".flk/derivation/build.sh",
+	"installPhase": ".flk/derivation/install.sh",
+	"shellHook":    ".flk/devenv/shellhook.sh",
+}
+
+var (
+	runningPhaseRegex    = regexp.MustCompile(`Running phase: (\w+)`)
+	commandNotFoundRegex = regexp.MustCompile(`([^\s:]+): command not found`)
+)
+
+// returns the nix binary, $FLK_NIX takes precedence over PATH
+func nixBinary() (string, error) {
+	if bin := os.Getenv("FLK_NIX"); bin != "" {
+		return bin, nil
+	}
+	bin, err := exec.LookPath("nix")
+	if err != nil {
+		return "", fmt.Errorf("nix not found in PATH, set FLK_NIX to its location")
+	}
+	return bin, nil
+}
+
+// reports whether anything in the .flk folder next to path was modified after path
+func flkNewerThan(path string) (bool, error) {
+	flakeInfo, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	newer := false
+	err = filepath.WalkDir(filepath.Join(filepath.Dir(path), ".flk"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(flakeInfo.ModTime()) {
+			newer = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return newer, err
+}
+
+// reports whether .flk, or that of a workspace member, changed since the flake was last written
+func flkStale(dir string) (bool, error) {
+	path := filepath.Join(dir, "flake.nix")
+	if stale, err := flkNewerThan(path); err != nil || stale {
+		return stale, err
+	}
+	f, err := flake.Load(path)
+	if err != nil {
+		return false, err
+	}
+	members, err := f.Members()
+	if err != nil {
+		return false, err
+	}
+	for _, m := range members {
+		if stale, err := flkNewerThan(f.MemberPath(m)); err != nil || stale {
+			return stale, err
+		}
+	}
+	return false, nil
+}
+
+// applies .flk to flake.nix
+func applyFlk(dir string) error {
+	f, err := flake.Load(filepath.Join(dir, "flake.nix"))
+	if err != nil {
+		return err
+	}
+	if err := f.Apply(); err != nil {
+		return err
+	}
+	return f.Save()
+}
+
+// applies .flk when it changed since flake.nix was last written
+func applyIfStale(dir string) error {
+	stale, err := flkStale(dir)
+	if err != nil {
+		return fmt.Errorf("could not compare .flk with flake.nix: %w", err)
+	}
+	if !stale {
+		return nil
+	}
+	report("Applying .flk changes to flake.nix")
+	return applyFlk(dir)
+}
+
+// builds a flake reference such as .#default
+func flakeRef(dir, attr string) string {
+	if attr == "" {
+		return dir
+	}
+	return dir + "#" + attr
+}
+
+// runs nix with args in dir, summarising the failure if it fails
+func runNix(dir string, args ...string) error {
+	bin, err := nixBinary()
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	// keep stdout for the json document
+	if outputFormat == outputJSON {
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+	if err := cmd.Run(); err != nil {
+		if summary := summarizeNixFailure(dir, stderr.String()); summary != "" {
+			return fmt.Errorf("nix %s failed: %s", args[0], summary)
+		}
+		return fmt.Errorf("nix %s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// points a failure at the .flk script line that caused it when possible
+func summarizeNixFailure(dir, stderr string) string {
+	lines := strings.Split(strings.TrimRight(stderr, "\n"), "\n")
+
+	phase := ""
+	for _, l := range lines {
+		if m := runningPhaseRegex.FindStringSubmatch(l); m != nil {
+			phase = m[1]
+		}
+	}
+
+	// last line of the build log, or of nix's own output
+	last := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		t := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(t, ">") && !runningPhaseRegex.MatchString(t) {
+			last = strings.TrimSpace(strings.TrimPrefix(t, ">"))
+			break
+		}
+		if last == "" && t != "" && !strings.HasPrefix(t, "For full logs") {
+			last = t
+		}
+	}
+
+	script, ok := phaseScripts[phase]
+	if !ok {
+		return last
+	}
+
+	// find the script line running the failing command
+	needle := ""
+	if m := commandNotFoundRegex.FindStringSubmatch(stderr); m != nil {
+		needle = m[1]
+	}
+	if needle != "" {
+		if content, err := os.ReadFile(filepath.Join(dir, script)); err == nil {
+			for i, l := range strings.Split(string(content), "\n") {
+				if strings.Contains(l, needle) {
+					return fmt.Sprintf("%s failed at %s:%d: %s (%s)", phase, script, i+1, strings.TrimSpace(l), last)
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("%s failed, see %s (%s)", phase, script, last)
+}
+
+// allows a single attribute before --
+func attrBeforeDash(cmd *cobra.Command, args []string) error {
+	n := cmd.ArgsLenAtDash()
+	if n == -1 {
+		n = len(args)
+	}
+	if n > 1 {
+		return fmt.Errorf("accepts at most 1 attribute before --, received %d", n)
+	}
+	return nil
+}
+
+// splits wrapper args into the attribute and the args after --
+func splitWrapperArgs(args []string, dash int) (string, []string) {
+	if dash == -1 {
+		dash = len(args)
+	}
+	attr := ""
+	if dash > 0 {
+		attr = args[0]
+	}
+	return attr, args[dash:]
+}
+
+// the devShell `flk develop` enters without an attribute, the configured shellName when the flake has no
+// default one
+func defaultShellAttr(dir string) (string, error) {
+	if config.ShellName == "" || config.ShellName == "default" {
+		return "", nil
+	}
+	f, err := flake.Load(filepath.Join(dir, "flake.nix"))
+	if err != nil {
+		return "", err
+	}
+	shells, err := f.DevShells()
+	if err != nil {
+		return "", err
+	}
+	attr := ""
+	for _, s := range shells {
+		switch s.Name {
+		case "default":
+			return "", nil
+		case config.ShellName:
+			attr = s.Name
+		}
+	}
+	return attr, nil
+}
+
+// applies stale .flk changes and runs `nix <sub> <ref> <extra...>` in dir
+func runNixWrapper(dir string, sub []string, attr string, withRef bool, extra []string) error {
+	if err := applyIfStale(dir); err != nil {
+		return err
+	}
+	// the devShell doesn't copy the sources, builds do
+	if sub[0] != "develop" {
+		f, err := flake.Load(filepath.Join(dir, "flake.nix"))
+		if err != nil {
+			return err
+		}
+		if files, err := untrackedSources(f); err != nil {
+			return err
+		} else if len(files) > 0 {
+			report(fmt.Sprintf("warning: src = ./. leaves out files git doesn't track: %s, stage them with git add -N", summarizeFiles(files)))
+		}
+	}
+
+	args := append([]string{}, sub...)
+	if withRef {
+		args = append(args, flakeRef(".", attr))
+	}
+	if len(extra) > 0 {
+		if sub[0] == "run" {
+			args = append(args, "--")
+		}
+		args = append(args, extra...)
+	}
+	return runNix(dir, args...)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"flk/pkg/flake"
+)
+
+func TestDefaultShellAttr(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+
+	dir := t.TempDir()
+	f, err := flake.NewWithOptions(filepath.Join(dir, "flake.nix"), flake.StyleFlakeUtils, flake.Options{ShellName: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ shellName, want string }{
+		{"default", ""},
+		{"dev", "dev"},
+		// a flake made before the setting changed keeps its own shell
+		{"ci", ""},
+	} {
+		config.ShellName = tt.shellName
+		got, err := defaultShellAttr(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("with shellName %s develop enters %q, want %q", tt.shellName, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"flk/pkg/flake"
+)
+
+// formats for --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var outputFormat = outputText
+
+var (
+	// messages reported while running a command under --output json
+	reported []string
+	// set once a command printed its own json document
+	resultPrinted bool
+)
+
+func validateOutputFormat() error {
+	if outputFormat != outputText && outputFormat != outputJSON {
+		return fmt.Errorf("unknown output format %q, expected %s or %s", outputFormat, outputText, outputJSON)
+	}
+	return nil
+}
+
+// writes v as indented json to stdout
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return &flake.Error{Kind: flake.KindIO, Err: fmt.Errorf("could not write the json output: %w", err)}
+	}
+	return nil
+}
+
+// prints v as json, or runs text under --output text
+func printResult(v interface{}, text func()) {
+	if outputFormat == outputJSON {
+		if err := printJSON(v); err != nil {
+			fail(err)
+		}
+		resultPrinted = true
+		return
+	}
+	text()
+}
+
+// prints a status message, collected into the json document under --output json
+func report(a ...interface{}) {
+	if outputFormat == outputJSON {
+		reported = append(reported, strings.TrimSuffix(fmt.Sprintln(a...), "\n"))
+		return
+	}
+	fmt.Println(a...)
+}
+
+// prints the collected messages when the command had no result of its own
+func flushReport() {
+	if outputFormat != outputJSON || resultPrinted {
+		return
+	}
+	messages := reported
+	if messages == nil {
+		messages = []string{}
+	}
+	if err := printJSON(map[string]interface{}{"messages": messages}); err != nil {
+		fail(err)
+	}
+}
+
+// prints err and exits with the status of its kind, as a json object under --output json
+func fail(err error) {
+	if outputFormat != outputJSON {
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+	// the result printed by the command tells what failed, a second document would break the json
+	if resultPrinted {
+		os.Exit(exitCode(err))
+	}
+	doc := map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":     string(flake.KindOf(err)),
+			"exitCode": exitCode(err),
+			"message":  err.Error(),
+		},
+	}
+	if len(reported) > 0 {
+		doc["messages"] = reported
+	}
+	if jsonErr := printJSON(doc); jsonErr != nil {
+		// stdout is broken, stderr still tells what failed
+		log.Print(err)
+	}
+	os.Exit(exitCode(err))
+}
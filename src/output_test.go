@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"flk/pkg/flake"
+)
+
+func TestPrintJSONFails(t *testing.T) {
+	err := printJSON(map[string]interface{}{"value": make(chan int)})
+	if flake.KindOf(err) != flake.KindIO || exitCode(err) != 7 {
+		t.Errorf("an unencodable value gave %v, want an io error", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"flk/pkg/flake"
+)
+
+// overview of a flk managed flake for `flk flake status`
+type flakeStatus struct {
+	File       string             `json:"file"`
+	Style      string             `json:"style"`
+	Systems    []string           `json:"systems"`
+	Packages   int                `json:"packages"`
+	Derivation *flake.PackageYAML `json:"derivation"`
+	Inputs     int                `json:"inputs"`
+	Locked     bool               `json:"locked"`
+	Stale      bool               `json:"stale"`
+}
+
+// collects the status of the flake and its .flk folder
+func getFlakeStatus(filePath string) (*flakeStatus, error) {
+	f, err := flake.Load(filePath)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filePath)
+
+	status := &flakeStatus{File: filePath, Style: f.Style()}
+	if status.Systems, err = f.Systems(); err != nil {
+		status.Systems = []string{}
+	}
+	pkgs, err := f.Packages()
+	if err != nil {
+		return nil, err
+	}
+	status.Packages = len(pkgs)
+
+	inputs, err := f.Inputs()
+	if err != nil {
+		return nil, err
+	}
+	status.Inputs = len(inputs)
+	if _, err := os.Stat(filepath.Join(dir, "flake.lock")); err == nil {
+		status.Locked = true
+	}
+
+	if drv, err := f.Derivation(); err != nil {
+		return nil, err
+	} else if drv != nil {
+		pkg, err := f.PackageYAML()
+		if err != nil {
+			return nil, err
+		}
+		status.Derivation = &pkg
+	}
+	if f.IsMember() {
+		status.Stale, err = flkNewerThan(filePath)
+	} else {
+		status.Stale, err = flkStale(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not compare .flk with flake.nix: %w", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"flk/pkg/flake"
+)
+
+// adds the template in src, a directory or a git repository that is cloned first
+func addTemplate(name, src string) (flake.Template, error) {
+	if info, err := os.Stat(src); err == nil && info.IsDir() {
+		return flake.AddTemplate(name, src)
+	}
+
+	checkout, err := os.MkdirTemp("", "flk-template-")
+	if err != nil {
+		return flake.Template{}, fmt.Errorf("could not create a directory to clone %s: %w", src, err)
+	}
+	defer os.RemoveAll(checkout)
+
+	cmd := exec.Command("git", "clone", "--depth", "1", src, checkout)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return flake.Template{}, fmt.Errorf("git clone failed: %s", strings.TrimSpace(string(out)))
+	}
+	return flake.AddTemplate(name, checkout)
+}
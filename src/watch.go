@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flk/pkg/flake"
+	"github.com/fsnotify/fsnotify"
+)
+
+// time flk watch waits for further events before applying, editors write a file in several steps
+const watchDebounce = 200 * time.Millisecond
+
+// changed lines shown per file after an apply
+const watchShownLines = 10
+
+// change to a file by one apply of flk watch
+type fileChange struct {
+	File    string   `json:"file"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// the flake, and the flk.nix of every workspace member, which Apply rewrites
+func appliedFiles(f *flake.Flake) ([]string, error) {
+	paths := []string{f.Path}
+	members, err := f.Members()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		paths = append(paths, f.MemberPath(m))
+	}
+	return paths, nil
+}
+
+// adds every directory of the .flk folders next to paths to the watcher, fsnotify does not recurse
+func watchFlkDirs(w *fsnotify.Watcher, paths []string) error {
+	for _, p := range paths {
+		root := filepath.Join(filepath.Dir(p), ".flk")
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			return w.Add(path)
+		})
+		if err != nil {
+			return fmt.Errorf("could not watch %s: %w", root, err)
+		}
+	}
+	return nil
+}
+
+// applies .flk to the flake at filePath and returns what changed in the files it wrote
+func watchApply(filePath string) ([]fileChange, error) {
+	f, err := flake.Load(filePath)
+	if err != nil {
+		return nil, err
+	}
+	f.Options = config.Options()
+	paths, err := appliedFiles(f)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]string{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", p, err)
+		}
+		before[p] = string(data)
+	}
+
+	if err := f.Apply(); err != nil {
+		return nil, err
+	}
+	if err := f.Save(); err != nil {
+		return nil, err
+	}
+
+	var changes []fileChange
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", p, err)
+		}
+		added, removed := diffLines(before[p], string(data))
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		changes = append(changes, fileChange{File: p, Added: added, Removed: removed})
+	}
+	return changes, nil
+}
+
+// returns the lines of b not in a and the lines of a not in b, from their longest common subsequence
+func diffLines(a, b string) (added, removed []string) {
+	added, removed = []string{}, []string{}
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, x[i])
+			i++
+		default:
+			added = append(added, y[j])
+			j++
+		}
+	}
+	removed = append(removed, x[i:]...)
+	added = append(added, y[j:]...)
+	return added, removed
+}
+
+// prints the summary of one apply
+func printChanges(changes []fileChange) {
+	if changes == nil {
+		changes = []fileChange{}
+	}
+	printResult(map[string]interface{}{"changes": changes}, func() {
+		for _, c := range changes {
+			log.Printf("Applied .flk to %s: +%d -%d", c.File, len(c.Added), len(c.Removed))
+			shown := 0
+			for _, prefix := range []string{"-", "+"} {
+				lines := c.Removed
+				if prefix == "+" {
+					lines = c.Added
+				}
+				for _, line := range lines {
+					if shown == watchShownLines {
+						break
+					}
+					fmt.Printf("  %s %s\n", prefix, strings.TrimSpace(line))
+					shown++
+				}
+			}
+			if total := len(c.Added) + len(c.Removed); total > shown {
+				fmt.Printf("  ... %d more\n", total-shown)
+			}
+		}
+	})
+}
+
+// prints an error of an apply without exiting, flk watch keeps going until the next change
+func printWatchError(err error) {
+	printResult(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    string(flake.KindOf(err)),
+			"message": err.Error(),
+		},
+	}, func() {
+		log.Printf("Could not apply .flk: %v", err)
+	})
+}
+
+// applies .flk to the flake at filePath whenever a file in it changes, until interrupted
+func watchFlk(filePath string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not start watching: %w", err)
+	}
+	defer w.Close()
+
+	f, err := flake.Load(filePath)
+	if err != nil {
+		return err
+	}
+	paths, err := appliedFiles(f)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(f.Dir(), ".flk")); os.IsNotExist(err) {
+		return &flake.Error{Kind: flake.KindNotFound, Err: fmt.Errorf("no .flk folder next to %s", filePath)}
+	}
+	if err := watchFlkDirs(w, paths); err != nil {
+		return err
+	}
+
+	apply := func() {
+		changes, err := watchApply(filePath)
+		if err != nil {
+			printWatchError(err)
+		} else if len(changes) > 0 {
+			printChanges(changes)
+		}
+		// pick up new directories and members added to the workspace
+		if f, err := flake.Load(filePath); err == nil {
+			if paths, err := appliedFiles(f); err == nil {
+				if err := watchFlkDirs(w, paths); err != nil {
+					printWatchError(err)
+				}
+			}
+		}
+	}
+
+	// bring flake.nix up to date before the first change
+	apply()
+	if outputFormat == outputText {
+		log.Printf("Watching %s, press Ctrl-C to stop", filepath.Join(f.Dir(), ".flk"))
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			debounce = time.After(watchDebounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			printWatchError(err)
+		case <-debounce:
+			debounce = nil
+			apply()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"flk/pkg/flake"
+)
+
+func TestDiffLines(t *testing.T) {
+	added, removed := diffLines("a\nb\nc\nd", "b\nc\nx\nd\na")
+	if want := []string{"x", "a"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added lines are %q, want %q", added, want)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed lines are %q, want %q", removed, want)
+	}
+
+	// unchanged files give empty lists, which are [] and not null in the json output
+	added, removed = diffLines("a\nb", "a\nb")
+	if added == nil || removed == nil || len(added)+len(removed) != 0 {
+		t.Errorf("an unchanged file gave %q and %q", added, removed)
+	}
+}
+
+func TestWatchApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flake.nix")
+	f, err := flake.New(path, flake.StyleFlakeUtils)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.GenerateFlk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if config, err = flake.LoadConfig(f.Dir()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first apply brings flake.nix up to date, the next one has nothing to do
+	if _, err := watchApply(path); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := watchApply(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("applying twice changed %+v", changes)
+	}
+
+	hook := filepath.Join(f.Dir(), ".flk/devenv/shellhook.sh")
+	if err := os.WriteFile(hook, []byte("echo watched\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = watchApply(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].File != path || !strings.Contains(strings.Join(changes[0].Added, "\n"), "echo watched") {
+		t.Errorf("editing the shellHook gave %+v", changes)
+	}
+}
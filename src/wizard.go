@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// languages the wizard offers, each is a built-in template
+var wizardLanguages = []string{"go", "rust", "python", "none"}
+
+// files that give away the language of a project
+var languageMarkers = []struct {
+	File     string
+	Language string
+}{
+	{"go.mod", "go"},
+	{"Cargo.toml", "rust"},
+	{"pyproject.toml", "python"},
+	{"requirements.txt", "python"},
+	{"setup.py", "python"},
+}
+
+// answers of the init wizard
+type wizardAnswers struct {
+	Name       string
+	Version    string
+	Language   string
+	Systems    []string
+	Packages   []string
+	Direnv     bool
+	Derivation bool
+}
+
+// template the language is generated from
+func (a wizardAnswers) template() string {
+	if a.Language == "none" {
+		return "default"
+	}
+	return a.Language
+}
+
+// guesses the language of the project in dir, none when nothing matches
+func detectLanguage(dir string) string {
+	for _, m := range languageMarkers {
+		if _, err := os.Stat(filepath.Join(dir, m.File)); err == nil {
+			return m.Language
+		}
+	}
+	return "none"
+}
+
+// asks the questions of flk flake init -i, with yes every default is taken without asking
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+	yes bool
+}
+
+// asks question and returns the answer, def when it is empty or input ended
+func (w *wizard) ask(question, def string) string {
+	if w.yes {
+		return def
+	}
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil {
+		// no more input, take the remaining defaults
+		w.yes = true
+		fmt.Fprintln(w.out)
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+func (w *wizard) askBool(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer := strings.ToLower(w.ask(question+" ("+hint+")", ""))
+		switch answer {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(w.out, "Please answer y or n")
+	}
+}
+
+func (w *wizard) askChoice(question string, choices []string, def string) string {
+	for {
+		answer := w.ask(question+" ("+strings.Join(choices, ", ")+")", def)
+		for _, c := range choices {
+			if answer == c {
+				return c
+			}
+		}
+		fmt.Fprintf(w.out, "Please pick one of %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// asks for packages until an empty answer, searching the package index when there is one
+func (w *wizard) askPackages() []string {
+	var packages []string
+	index, err := loadPackageIndex()
+	if err != nil && !w.yes {
+		fmt.Fprintln(w.out, "No package index to search, packages are added as typed. Build it with: flk package index")
+	}
+	for {
+		query := w.ask("Add a package (name or search term, empty when done)", "")
+		if query == "" {
+			return packages
+		}
+		if index == nil {
+			packages = append(packages, query)
+			continue
+		}
+		if _, ok := lookupPackage(index, query); ok {
+			packages = append(packages, query)
+			continue
+		}
+
+		matches := searchPackages(index, query, 10)
+		if len(matches) == 0 {
+			fmt.Fprintf(w.out, "No packages match %q\n", query)
+			continue
+		}
+		for i, p := range matches {
+			fmt.Fprintf(w.out, "  %2d) %s %s  %s\n", i+1, p.Attr, p.Version, p.Description)
+		}
+		pick := w.ask("Pick a number, empty to search again", "")
+		n, err := strconv.Atoi(pick)
+		if err != nil || n < 1 || n > len(matches) {
+			continue
+		}
+		packages = append(packages, matches[n-1].Attr)
+	}
+}
+
+// runs the init wizard for a flake in dir, systems and derivation are the defaults from flags and config
+func runInitWizard(in io.Reader, out io.Writer, dir string, yes bool, systems []string, derivation bool) wizardAnswers {
+	w := &wizard{in: bufio.NewReader(in), out: out, yes: yes}
+
+	a := wizardAnswers{}
+	a.Name = w.ask("Project name", filepath.Base(dir))
+	a.Version = w.ask("Version", "0.1")
+	a.Language = w.askChoice("Language", wizardLanguages, detectLanguage(dir))
+
+	defSystems := strings.Join(systems, ",")
+	if defSystems == "" {
+		defSystems = "default"
+	}
+	if systems := w.ask("Systems, comma separated or default", defSystems); systems != "default" {
+		for _, s := range strings.Split(systems, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				a.Systems = append(a.Systems, s)
+			}
+		}
+	}
+
+	a.Packages = w.askPackages()
+
+	_, err := exec.LookPath("direnv")
+	a.Direnv = w.askBool("Enable direnv", err == nil)
+	a.Derivation = w.askBool("Build a package from the project (derivation)", derivation)
+	return a
+}
+
+// writes an .envrc loading the flake, an existing one is kept
+func writeEnvrc(dir string) (bool, error) {
+	path := filepath.Join(dir, ".envrc")
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	if err := os.WriteFile(path, []byte("use flake\n"), 0644); err != nil {
+		return false, fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return true, nil
+}